@@ -2,6 +2,7 @@
 #include "attr_check.h"
 #include<cstring>
 #include<gpfs.h>
+#include<gpfs_fcntl.h>
 #include<vector>
 using namespace std;
 
@@ -19,6 +20,32 @@ vector<char> clean(char* buf, int size) {
 	return v;
 }
 
+/*
+ * Reads the extended (including DMAPI) attributes of path and returns the printable characters.
+ * Attribute boundaries are marked with '|'. Returns an empty string if the file can't be read
+ */
+string read_attrs(char* path) {
+	FILE* f = fopen(path, "rb");
+	if (f == NULL) {
+		return "";
+	}
+
+	char* buffer = (char*) calloc(1024, sizeof(char));
+	int attrSize = 0;
+	int rc = gpfs_fgetattrs(fileno(f), GPFS_ATTRFLAG_INCL_DMAPI, buffer, 1024*sizeof(char), &attrSize);
+	fclose(f);
+
+	string str = "";
+	if (rc == 0) {
+		vector<char> vectorized_buf = clean(buffer, attrSize);
+		for (auto c: vectorized_buf) {
+			str += c;
+		}
+	}
+	free(buffer);
+	return str;
+}
+
 extern "C" {
 int print() {
 	cout << "Test from C++" << endl;
@@ -34,17 +61,7 @@ int print() {
  * 2: file is migrated
  */
 int attr_check(char* path) {
-	FILE* f = fopen(path, "rb");
-
-	char* buffer = (char*) calloc(1024, sizeof(char));
-	int attrSize = 0;
-	int rc = gpfs_fgetattrs(fileno(f), GPFS_ATTRFLAG_INCL_DMAPI, buffer, 1024*sizeof(char), &attrSize);
-
-	vector<char> vectorized_buf = clean(buffer, attrSize);
-	string str = "";
-	for (auto c: vectorized_buf) {
-		str += c;
-	}
+	string str = read_attrs(path);
 
 	if (str.find("IBMTPS") != string::npos) {
 		if (str.find("IBMPMig") != string::npos) {
@@ -56,7 +73,49 @@ int attr_check(char* path) {
 		}
 	}
 
-	fclose(f);
 	//cout << "File is resident" << endl;
 	return 0;
 }
+
+/*
+ * Copies the printable attributes of path (see read_attrs) into out, truncating to outSize-1 characters.
+ * Returns the number of characters copied
+ */
+int attr_dump(char* path, char* out, int outSize) {
+	string str = read_attrs(path);
+	int n = min((int)str.size(), outSize-1);
+	memcpy(out, str.c_str(), n);
+	out[n] = '\0';
+	return n;
+}
+
+/*
+ * Copies the name of the GPFS storage pool holding the disk data of path into out.
+ * Returns 0 on success, -1 if the pool could not be determined
+ */
+int storage_pool(char* path, char* out, int outSize) {
+	FILE* f = fopen(path, "rb");
+	if (f == NULL) {
+		return -1;
+	}
+
+	struct {
+		gpfsFcntlHeader_t hdr;
+		gpfsGetStoragePool_t pool;
+	} arg;
+	arg.hdr.totalLength = sizeof(arg);
+	arg.hdr.fcntlVersion = GPFS_FCNTL_CURRENT_VERSION;
+	arg.hdr.fcntlReserved = 0;
+	arg.pool.structLen = sizeof(arg.pool);
+	arg.pool.structType = GPFS_FCNTL_GET_STORAGEPOOL;
+
+	int rc = gpfs_fcntl(fileno(f), &arg);
+	fclose(f);
+	if (rc != 0) {
+		return -1;
+	}
+
+	strncpy(out, arg.pool.buffer, outSize-1);
+	out[outSize-1] = '\0';
+	return 0;
+}
@@ -4,6 +4,8 @@ extern "C" {
 #ifndef ATTR_CHECK_H
 #define ATTR_CHECK_H
 	int attr_check(char* path);
+	int attr_dump(char* path, char* out, int outSize);
+	int storage_pool(char* path, char* out, int outSize);
 	int print();
 #endif
 #ifdef __cplusplus
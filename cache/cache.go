@@ -0,0 +1,260 @@
+// Package cache keeps the storage states gls looked up in files per user and directory, so listing the same large archive
+// directories again skips the attribute calls. A cached state is only used while the file's device, inode, ctime
+// and size are unchanged and it isn't older than MaxAge.
+package cache
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/olcf/gls/storage"
+)
+
+// Bumped whenever the file format changes in a way older versions of gls can't read. Files of another version
+// are ignored and overwritten
+const Version = 3
+
+// How much the cache is trusted, see --cache
+const (
+	// Neither read nor written
+	Off = "off"
+	// Unchanged files younger than MaxAge are answered from the cache, the others are looked up and stored
+	Read = "read"
+	// Every file is looked up and stored
+	Refresh = "refresh"
+)
+
+// What a cached state is only valid for. Recalls and migrations don't always change the ctime, but they do change
+// the blocks allocated on disk, since migrating a file frees them and recalling it allocates them again.
+// Premigration changes neither, which is what MaxAge bounds
+type Key struct {
+	Dev    uint64 `json:"d"`
+	Ino    uint64 `json:"i"`
+	Ctime  int64  `json:"c"`
+	Size   int64  `json:"s"`
+	Blocks int64  `json:"b"`
+}
+
+// The key of the file info describes, false if the filesystem doesn't give inode numbers
+func KeyOf(info fs.FileInfo) (Key, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Key{}, false
+	}
+	return Key{Dev: uint64(st.Dev), Ino: st.Ino, Ctime: st.Ctim.Nano(), Size: st.Size, Blocks: st.Blocks}, true
+}
+
+// A cached lookup. Field names are kept short since the file holds one of these per file
+type Record struct {
+	Key   Key           `json:"k"`
+	State storage.State `json:"t"`
+	// Only set when Pools is
+	Pool       string `json:"p,omitempty"`
+	Attributes string `json:"a,omitempty"`
+	Pools      bool   `json:"o,omitempty"`
+	// When the state was looked up, in Unix seconds
+	Verified int64 `json:"v"`
+}
+
+// One directory's records as they are stored
+type file struct {
+	Version int    `json:"version"`
+	Dir     string `json:"dir"`
+	// By file name
+	Records map[string]Record `json:"records"`
+}
+
+// The records of one directory, read when first needed
+type shard struct {
+	records map[string]Record
+	dirty   bool
+}
+
+// The cached states of one user, in a file per directory so a run only reads and writes the directories it lists.
+// Safe for concurrent use
+type Cache struct {
+	// One of Off, Read or Refresh
+	Mode string
+	// Records older than this are looked up again, and directories not written for this long are removed by Save
+	MaxAge time.Duration
+	// Records kept per directory by Save, dropping the least recently verified
+	Entries int
+
+	dir    string
+	mu     sync.Mutex
+	shards map[string]*shard
+}
+
+// $XDG_CACHE_HOME/gls/states, or ~/.cache/gls/states when it isn't set
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gls", "states"), nil
+}
+
+// The cache in the directory at path. Nothing is read until the records of a listed directory are needed
+func Open(path, mode string, maxAge time.Duration, entries int) *Cache {
+	return &Cache{Mode: mode, MaxAge: maxAge, Entries: entries, dir: path, shards: make(map[string]*shard)}
+}
+
+// File holding the records of dir
+func (c *Cache) shardPath(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16])+".json.gz")
+}
+
+// The records of the files in dir. Must hold c.mu
+func (c *Cache) shard(dir string) *shard {
+	s, ok := c.shards[dir]
+	if !ok {
+		s = &shard{records: c.read(dir)}
+		c.shards[dir] = s
+	}
+	return s
+}
+
+// Read the records of dir. A missing, unreadable or outdated file is an empty directory, since it will be rebuilt anyway
+func (c *Cache) read(dir string) map[string]Record {
+	records := make(map[string]Record)
+	f, err := os.Open(c.shardPath(dir))
+	if err != nil {
+		return records
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return records
+	}
+	var data file
+	if err := json.NewDecoder(r).Decode(&data); err == nil && data.Version == Version && data.Dir == dir && data.Records != nil {
+		records = data.Records
+	}
+	return records
+}
+
+// The record for path if its key still matches, it is younger than MaxAge and holds the pools when they are
+// wanted. Always false with Refresh
+func (c *Cache) Get(path string, key Key, pools bool) (Record, bool) {
+	if c == nil || c.Mode != Read {
+		return Record{}, false
+	}
+	c.mu.Lock()
+	r, ok := c.shard(filepath.Dir(path)).records[filepath.Base(path)]
+	c.mu.Unlock()
+	if !ok || r.Key != key || (pools && !r.Pools) || time.Since(time.Unix(r.Verified, 0)) >= c.MaxAge {
+		return Record{}, false
+	}
+	return r, true
+}
+
+// Remember a fresh lookup of path
+func (c *Cache) Put(path string, r Record) {
+	if c == nil || c.Mode == Off {
+		return
+	}
+	if r.Verified == 0 {
+		r.Verified = time.Now().Unix()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.shard(filepath.Dir(path))
+	s.records[filepath.Base(path)] = r
+	s.dirty = true
+}
+
+// Write back the directories something was stored for, readable only by the user, and remove those not written
+// for MaxAge. Files are replaced in one go so a gls running at the same time never reads half of one
+func (c *Cache) Save() error {
+	if c == nil || c.Mode == Off {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wrote := false
+	for dir, s := range c.shards {
+		if !s.dirty {
+			continue
+		}
+		if err := c.write(dir, s); err != nil {
+			return err
+		}
+		s.dirty = false
+		wrote = true
+	}
+	if wrote {
+		c.prune()
+	}
+	return nil
+}
+
+// Must hold c.mu
+func (c *Cache) write(dir string, s *shard) error {
+	s.trim(c.Entries)
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	path := c.shardPath(dir)
+	tmp, err := os.CreateTemp(c.dir, ".states-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := gzip.NewWriter(tmp)
+	err = json.NewEncoder(w).Encode(file{Version: Version, Dir: dir, Records: s.records})
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Remove the files of directories not written for MaxAge, whose records are all too old to be used
+func (c *Cache) prune() {
+	if c.MaxAge <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && strings.HasSuffix(e.Name(), ".json.gz") && time.Since(info.ModTime()) >= c.MaxAge {
+			os.Remove(filepath.Join(c.dir, e.Name()))
+		}
+	}
+}
+
+// Drop the least recently verified records beyond entries
+func (s *shard) trim(entries int) {
+	if entries <= 0 || len(s.records) <= entries {
+		return
+	}
+	names := make([]string, 0, len(s.records))
+	for name := range s.records {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return s.records[names[i]].Verified < s.records[names[j]].Verified
+	})
+	for _, name := range names[:len(names)-entries] {
+		delete(s.records, name)
+	}
+}
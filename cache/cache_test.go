@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/olcf/gls/storage"
+)
+
+func TestGet(t *testing.T) {
+	key := Key{Dev: 1, Ino: 2, Ctime: 3, Size: 4, Blocks: 8}
+	path := filepath.Join(t.TempDir(), "states")
+	c := Open(path, Read, time.Hour, 10)
+	c.Put("/a", Record{Key: key, State: storage.Migrated})
+	c.Put("/old", Record{Key: key, State: storage.Migrated, Verified: time.Now().Add(-2 * time.Hour).Unix()})
+
+	tests := []struct {
+		path  string
+		key   Key
+		pools bool
+		want  bool
+	}{
+		{"/a", key, false, true},
+		{"/missing", key, false, false},
+		// Changed since
+		{"/a", Key{Dev: 1, Ino: 2, Ctime: 5, Size: 4, Blocks: 8}, false, false},
+		{"/a", Key{Dev: 1, Ino: 2, Ctime: 3, Size: 6, Blocks: 8}, false, false},
+		// Migrated or recalled without a new ctime
+		{"/a", Key{Dev: 1, Ino: 2, Ctime: 3, Size: 4}, false, false},
+		// Looked up without the pools
+		{"/a", key, true, false},
+		// Older than MaxAge
+		{"/old", key, false, false},
+	}
+	for _, test := range tests {
+		if _, have := c.Get(test.path, test.key, test.pools); have != test.want {
+			t.Fatalf("cache.Get(%v, %v, %v) = %v; want %v", test.path, test.key, test.pools, have, test.want)
+		}
+	}
+
+	c.Mode = Refresh
+	if _, have := c.Get("/a", key, false); have {
+		t.Fatalf("cache.Get() with %v = %v; want %v", Refresh, have, false)
+	}
+}
+
+func TestSave(t *testing.T) {
+	key := Key{Dev: 1, Ino: 2, Ctime: 3, Size: 4}
+	path := filepath.Join(t.TempDir(), "gls", "states")
+	c := Open(path, Read, time.Hour, 2)
+	c.Put("/a", Record{Key: key, State: storage.Migrated, Verified: time.Now().Add(-time.Minute).Unix()})
+	c.Put("/b", Record{Key: key, State: storage.Premigrated, Pool: "system", Pools: true})
+	c.Put("/c", Record{Key: key, State: storage.Resident})
+	c.Get("/other/d", key, false)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(c.shardPath("/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("cache file mode = %v; want %v", perm, os.FileMode(0600))
+	}
+	// Directories that were only read aren't written
+	if _, err := os.Stat(c.shardPath("/other")); !os.IsNotExist(err) {
+		t.Fatalf("cache.Save() wrote %s, which had no new records", c.shardPath("/other"))
+	}
+
+	c = Open(path, Read, time.Hour, 2)
+	// The least recently verified record didn't fit
+	if _, have := c.Get("/a", key, false); have {
+		t.Fatalf("cache.Get(/a) after Save = %v; want %v", have, false)
+	}
+	r, ok := c.Get("/b", key, true)
+	if !ok || r.State != storage.Premigrated || r.Pool != "system" {
+		t.Fatalf("cache.Get(/b) after Save = %v, %v; want %v", r, ok, "premigrated in system")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "states")
+	c := Open(path, Refresh, time.Hour, 10)
+	c.Put("/old/a", Record{State: storage.Migrated})
+	c.Put("/new/a", Record{State: storage.Migrated})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.shardPath("/old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	c.Put("/new/b", Record{State: storage.Resident})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.shardPath("/old")); !os.IsNotExist(err) {
+		t.Fatalf("cache.Save() kept %s, last written %s ago", c.shardPath("/old"), 2*time.Hour)
+	}
+	c = Open(path, Read, time.Hour, 10)
+	if _, have := c.Get("/new/a", Key{}, false); !have {
+		t.Fatalf("cache.Get(/new/a) after Save = %v; want %v", have, true)
+	}
+}
+
+func TestOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "states")
+	c := Open(path, Off, time.Hour, 10)
+	c.Put("/a", Record{State: storage.Migrated})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("cache.Save() with %v wrote %v", Off, path)
+	}
+	// A nil cache is the same as none
+	var none *Cache
+	if _, have := none.Get("/a", Key{}, false); have {
+		t.Fatalf("(*Cache)(nil).Get() = %v; want %v", have, false)
+	}
+	none.Put("/a", Record{})
+	if err := none.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+// glsd caches the storage state of files for every gls on the machine. See package daemon
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/daemon"
+	"github.com/olcf/gls/storage"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+
+	socket := kingpin.Flag("socket", "Unix socket to listen on").Default(config.DaemonSocket).String()
+	ttl := kingpin.Flag("ttl", "How long to trust the cached state of a file that hasn't changed").Default(config.DaemonCacheTTL.String()).Duration()
+	entries := kingpin.Flag("entries", "Maximum number of files to cache").Default(strconv.Itoa(config.DaemonCacheEntries)).Int()
+	workers := kingpin.Flag("workers", "Maximum number of files to look up at once").Default(strconv.Itoa(config.DaemonWorkers)).Int()
+	debug := kingpin.Flag("debug", "Display debug information").Short('v').Bool()
+	kingpin.Parse()
+
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if *debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+	if len(*socket) == 0 {
+		log.Fatal().Msg("No socket configured (config.DaemonSocket or --socket)")
+	}
+
+	l, err := daemon.Listen(*socket)
+	if err != nil {
+		log.Fatal().Msgf("Unable to listen on %s: %s", *socket, err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Also removes the socket
+		l.Close()
+	}()
+
+	log.Info().Msgf("Listening on %s", *socket)
+	server := daemon.NewServer(storage.DefaultBackend, *ttl, *entries, *workers)
+	if err := server.Serve(l); err != nil {
+		log.Fatal().Msgf("%s", err)
+	}
+}
@@ -1,15 +1,30 @@
 package columnize
 
 import (
-	"os"
-	"text/tabwriter"
 	"fmt"
+	"io"
 	"strings"
 )
 
 type Color string
 
-var writer *tabwriter.Writer
+// How a column's cells are padded out to the column width
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignRight
+)
+
+// A table of rows that is written out as aligned columns. Rows are buffered until Flush since every
+// row has to be seen before the column widths are known. Separate tables can be used concurrently
+type Table struct {
+	output  io.Writer
+	colors  bool
+	padding int
+	align   []Align
+	rows    [][]string
+}
 
 const (
 	Reset Color           = "\x1b[000000m"
@@ -20,13 +35,24 @@ const (
 	LightBlue             = "\x1b[000036m"
 	White                 = "\x1b[000037m"
 	BlinkingRedBackground = "\x1b[0041;5m"
+	BoldGreen             = "\x1b[0001;32m"
+	BoldYellow            = "\x1b[0001;33m"
+	BoldRed               = "\x1b[0001;31m"
+	BoldMagenta           = "\x1b[0001;35m"
+	Orphan                = "\x1b[0040;31;1m"
+	Reverse               = "\x1b[7m"
+	Underline             = "\x1b[4m"
 )
 
 func (c *Color) String() string {
 	return string(*c)
 }
 
+// Wrap s in the color c. An empty color leaves s untouched so uncolored output has no escape sequences at all
 func Colorize(c Color, s string) string {
+	if len(c) == 0 {
+		return s
+	}
 	return string(c) + s + string(Reset)
 }
 
@@ -50,22 +76,84 @@ func ColumnizeRowMultiColor(data map[string]Color) []string {
 	return row
 }
 
-func PrintLine(line []string) {
-	fmt.Fprintln(writer, strings.Join(line, "\t"))
+// A table written to w with columns separated by padding spaces and aligned according to align.
+// Columns past the end of align are left aligned. Without colors any escape sequences in the cells
+// are dropped on output, so callers don't have to check the color policy themselves
+func NewTable(w io.Writer, colors bool, padding int, align ...Align) *Table {
+	return &Table{output: w, colors: colors, padding: padding, align: align}
 }
 
-func New() {
-	writer = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+// Add a row to the table. Cells are separated by tabs, so a single entry of line may hold several cells
+func (t *Table) PrintLine(line []string) {
+	t.rows = append(t.rows, strings.Split(strings.Join(line, "\t"), "\t"))
 }
 
-func NewAlignRight() {
-	writer = tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight|tabwriter.DiscardEmptyColumns)
+// Write out the buffered rows. The last cell of a row is not part of a column and is written as is,
+// and columns that are empty in every row are left out entirely
+func (t *Table) Flush() error {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row[:len(row)-1] {
+			if i == len(widths) {
+				widths = append(widths, -1)
+			}
+			if w := DisplayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+	var b, line strings.Builder
+	for _, row := range t.rows {
+		line.Reset()
+		for i, cell := range row {
+			if !t.colors {
+				cell = StripEscapes(cell)
+			}
+			if i == len(row)-1 {
+				line.WriteString(cell)
+				break
+			}
+			if widths[i] == 0 {
+				continue
+			}
+			fill := strings.Repeat(" ", widths[i]-DisplayWidth(cell))
+			if t.alignment(i) == AlignRight {
+				line.WriteString(fill + cell)
+			} else {
+				line.WriteString(cell + fill)
+			}
+			line.WriteString(strings.Repeat(" ", t.padding))
+		}
+		// An empty last cell would otherwise leave the padding of the previous column behind
+		if len(row) > 1 && len(row[len(row)-1]) == 0 {
+			b.WriteString(strings.TrimRight(line.String(), " "))
+		} else {
+			b.WriteString(line.String())
+		}
+		b.WriteString("\n")
+	}
+	t.rows = nil
+	_, err := io.WriteString(t.output, b.String())
+	return err
 }
 
-func Flush() {
-	writer.Flush()
+// Sizes in decimal units, e.g. 123.5 kB
+func HumanizeSize(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 }
 
-func getWriter() *tabwriter.Writer {
-	return writer
+func (t *Table) alignment(col int) Align {
+	if col < len(t.align) {
+		return t.align[col]
+	}
+	return AlignLeft
 }
@@ -0,0 +1,136 @@
+package columnize
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLSColors(t *testing.T) {
+	c := ParseLSColors("di=01;34:ln=target:or=40;31;01:ex=01;32:*.tar=01;31:*.TAR=00;31:*README=33:bogus:fi=blue")
+	tests := []struct {
+		name     string
+		mode     os.FileMode
+		broken   bool
+		want     Color
+		specific bool
+	}{
+		{"dir", os.ModeDir | 0755, false, SGR("01;34"), true},
+		{"link", os.ModeSymlink | 0777, false, "", false},
+		{"dangling", os.ModeSymlink | 0777, true, SGR("40;31;01"), true},
+		{"run.sh", 0755, false, SGR("01;32"), true},
+		{"data.tar", 0644, false, SGR("01;31"), true},
+		{"DATA.TAR", 0644, false, SGR("00;31"), true},
+		{"data.Tar", 0644, false, SGR("01;31"), true},
+		{"README", 0644, false, SGR("33"), true},
+		{"plain.txt", 0644, false, "", false},
+	}
+	for _, test := range tests {
+		have, specific := c.ColorFor(test.name, test.mode, test.broken)
+		if have != test.want || specific != test.specific {
+			t.Fatalf("columnize.ColorFor(%s) = %q, %t; want %q, %t", test.name, have, specific, test.want, test.specific)
+		}
+	}
+	if !c.LinkTarget() || ParseLSColors("ln=01;36").LinkTarget() {
+		t.Fatalf("columnize.LinkTarget() doesn't follow ln=target")
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	theme := DefaultTheme
+	file := "# site theme\nmigrated = 01;35 # magenta\n\nprecedence=background\n"
+	err := theme.parse(bufio.NewScanner(strings.NewReader(file)), "theme")
+	if err != nil {
+		t.Fatalf("columnize.parse(%q) = %s; want nil", file, err)
+	}
+	if theme.Migrated != SGR("01;35") || theme.Precedence != PrecedenceBackground || theme.Resident != DefaultTheme.Resident {
+		t.Fatalf("columnize.parse(%q) = %v; want migrated and precedence changed", file, theme)
+	}
+
+	file = "migrated=35\npalette=colorblind\nresident=32\n"
+	err = theme.parse(bufio.NewScanner(strings.NewReader(file)), "theme")
+	if err != nil {
+		t.Fatalf("columnize.parse(%q) = %s; want nil", file, err)
+	}
+	if theme.Migrated != ColorblindTheme.Migrated || theme.Resident != SGR("32") || theme.Precedence != PrecedenceBackground {
+		t.Fatalf("columnize.parse(%q) = %v; want colorblind palette with resident overridden", file, theme)
+	}
+
+	for _, bad := range []string{"migrated", "blue=34", "precedence=never", "palette=sepia", "migrated=blue", "migrated=31m"} {
+		if err := theme.parse(bufio.NewScanner(strings.NewReader(bad)), "theme"); err == nil {
+			t.Fatalf("columnize.parse(%q) = nil; want error", bad)
+		}
+	}
+
+	// Bad lines are skipped and the rest still applies
+	theme = DefaultTheme
+	file = "migrated=magenta\nresident=32\n"
+	err = theme.parse(bufio.NewScanner(strings.NewReader(file)), "theme")
+	if err == nil || !strings.HasPrefix(err.Error(), "theme:1:") {
+		t.Fatalf("columnize.parse(%q) = %v; want an error about line 1", file, err)
+	}
+	if theme.Migrated != DefaultTheme.Migrated || theme.Resident != SGR("32") {
+		t.Fatalf("columnize.parse(%q) = %v; want only resident changed", file, theme)
+	}
+
+	for p, want := range map[Precedence]bool{PrecedenceState: true, PrecedenceLSColors: true, "": false, "never": false} {
+		if have := p.Valid(); have != want {
+			t.Fatalf("columnize.Precedence(%q).Valid() = %t; want %t", p, have, want)
+		}
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	tests := map[string]int{
+		"file.txt":                           8,
+		Colorize(Green, "file.txt"):          8,
+		Colorize(Orphan, "bad"):              3,
+		"日本語.txt":                            10,
+		"café":                              4,
+		"\x1b]8;;file:///a\x07a\x1b]8;;\x07": 1,
+		"":                                   0,
+	}
+	for s, want := range tests {
+		if have := DisplayWidth(s); have != want {
+			t.Fatalf("columnize.DisplayWidth(%q) = %d; want %d", s, have, want)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	rows := [][]string{
+		{Colorize(Green, "-rw-r--r--"), "7", "", Colorize(Red, "short")},
+		{"drwxr-xr-x\t1024\t", "日本語"},
+	}
+	tests := []struct {
+		colors bool
+		want   string
+	}{
+		{true, Colorize(Green, "-rw-r--r--") + "    7 " + Colorize(Red, "short") + "\ndrwxr-xr-x 1024 日本語\n"},
+		{false, "-rw-r--r--    7 short\ndrwxr-xr-x 1024 日本語\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		table := NewTable(&buf, test.colors, 1, AlignLeft, AlignRight)
+		for _, row := range rows {
+			table.PrintLine(row)
+		}
+		if err := table.Flush(); err != nil {
+			t.Fatalf("columnize.Flush() = %s; want nil", err)
+		}
+		if have := buf.String(); have != test.want {
+			t.Fatalf("columnize.Flush(colors=%v) = %q; want %q", test.colors, have, test.want)
+		}
+	}
+}
+
+func TestHumanizeSize(t *testing.T) {
+	var testVal int64 = 123456
+	have := HumanizeSize(testVal)
+	want := "123.5 kB"
+	if have != want {
+		t.Fatalf("columnize.HumanizeSize(%d) = %s; want %s", testVal, have, want)
+	}
+}
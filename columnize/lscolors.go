@@ -0,0 +1,148 @@
+package columnize
+
+import (
+	"os"
+	"strings"
+)
+
+// Colors parsed from the LS_COLORS environment variable. See dircolors(1) for the format
+type LSColors struct {
+	// Two letter file type keys (di, ln, ex, ...)
+	types map[string]Color
+	// Suffix patterns (*.tar, *README, ...) without the leading *
+	suffixes map[string]Color
+	// The keys of suffixes in the order LS_COLORS has them, which settles ties between case insensitive matches
+	suffixOrder []string
+	// ln=target: links that aren't broken take the color of what they point to
+	linkTarget bool
+}
+
+// Build an SGR escape sequence from a list of codes such as "01;34"
+func SGR(codes string) Color {
+	return Color("\x1b[" + codes + "m")
+}
+
+// Whether codes is something SGR can turn into an escape sequence, i.e. numbers separated by semicolons
+func ValidSGR(codes string) bool {
+	for _, r := range codes {
+		if (r < '0' || r > '9') && r != ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse the value of LS_COLORS. Malformed entries are ignored just like ls does
+func ParseLSColors(env string) *LSColors {
+	c := &LSColors{
+		types:    make(map[string]Color),
+		suffixes: make(map[string]Color),
+	}
+	for _, entry := range strings.Split(env, ":") {
+		key, codes, found := strings.Cut(entry, "=")
+		if !found || len(key) == 0 || len(codes) == 0 {
+			continue
+		}
+		if key == "ln" && codes == "target" {
+			c.linkTarget = true
+			continue
+		}
+		if !ValidSGR(codes) {
+			continue
+		}
+		if strings.HasPrefix(key, "*") {
+			if _, ok := c.suffixes[key[1:]]; !ok {
+				c.suffixOrder = append(c.suffixOrder, key[1:])
+			}
+			c.suffixes[key[1:]] = SGR(codes)
+		} else {
+			c.types[key] = SGR(codes)
+		}
+	}
+	return c
+}
+
+// Whether LS_COLORS has ln=target, i.e. links that aren't broken should be colored like the file they point to
+func (c *LSColors) LinkTarget() bool {
+	return c != nil && c.linkTarget
+}
+
+// Look up the color of a file type key, falling back to the given keys in order
+func (c *LSColors) typeColor(keys ...string) (Color, bool) {
+	for _, key := range keys {
+		if color, ok := c.types[key]; ok {
+			return color, true
+		}
+	}
+	return "", false
+}
+
+// Longest suffix pattern matching name. Exact case matches win over case insensitive ones, then the first in LS_COLORS
+func (c *LSColors) suffixColor(name string) (Color, bool) {
+	var best string
+	for _, fold := range []bool{false, true} {
+		for _, suffix := range c.suffixOrder {
+			if len(suffix) <= len(best) || len(suffix) > len(name) {
+				continue
+			}
+			tail := name[len(name)-len(suffix):]
+			if tail == suffix || (fold && strings.EqualFold(tail, suffix)) {
+				best = suffix
+			}
+		}
+		if len(best) > 0 {
+			return c.suffixes[best], true
+		}
+	}
+	return "", false
+}
+
+// Color ls would use for a file. specific is false when nothing but the default file (fi/no) entry matched,
+// which lets the storage state take precedence for ordinary files
+func (c *LSColors) ColorFor(name string, mode os.FileMode, broken bool) (color Color, specific bool) {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		if broken {
+			return c.typeColor("or", "ln")
+		}
+		return c.typeColor("ln")
+	case mode.IsDir():
+		otherWritable := mode.Perm()&0002 != 0
+		sticky := mode&os.ModeSticky != 0
+		switch {
+		case sticky && otherWritable:
+			return c.typeColor("tw", "ow", "st", "di")
+		case otherWritable:
+			return c.typeColor("ow", "di")
+		case sticky:
+			return c.typeColor("st", "di")
+		}
+		return c.typeColor("di")
+	case mode&os.ModeNamedPipe != 0:
+		return c.typeColor("pi")
+	case mode&os.ModeSocket != 0:
+		return c.typeColor("so")
+	case mode&os.ModeCharDevice != 0:
+		return c.typeColor("cd")
+	case mode&os.ModeDevice != 0:
+		return c.typeColor("bd")
+	case mode&os.ModeSetuid != 0:
+		if color, ok := c.typeColor("su"); ok {
+			return color, true
+		}
+	case mode&os.ModeSetgid != 0:
+		if color, ok := c.typeColor("sg"); ok {
+			return color, true
+		}
+	case mode.Perm()&0111 != 0:
+		if color, ok := c.typeColor("ex"); ok {
+			return color, true
+		}
+	}
+	// Like ls, suffixes are only checked for files that are still plain files at this point
+	if color, ok := c.suffixColor(name); ok {
+		return color, true
+	}
+	color, _ = c.typeColor("fi", "no")
+	return color, false
+}
@@ -0,0 +1,189 @@
+package columnize
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// How storage state colors are combined with LS_COLORS
+type Precedence string
+
+const (
+	// Storage state colors replace LS_COLORS for files with a known state
+	PrecedenceState Precedence = "state"
+	// LS_COLORS picks the foreground, storage state is shown as the background color
+	PrecedenceBackground Precedence = "background"
+	// LS_COLORS wins whenever it has a specific entry (type or suffix) for a file
+	PrecedenceLSColors Precedence = "lscolors"
+)
+
+// Whether p is one of the precedences above
+func (p Precedence) Valid() bool {
+	switch p {
+	case PrecedenceState, PrecedenceBackground, PrecedenceLSColors:
+		return true
+	}
+	return false
+}
+
+// Colors used for storage states and the file types gls colors itself. Can be overridden by theme files
+type Theme struct {
+	Resident       Color
+	Premigrated    Color
+	Migrated       Color
+	ResidentBg     Color
+	PremigratedBg  Color
+	MigratedBg     Color
+	TooLarge       Color
+	Directory      Color
+	Symlink        Color
+	Orphan         Color
+	DirResident    Color
+	DirPremigrated Color
+	DirMigrated    Color
+	DirMixed       Color
+	Precedence     Precedence
+}
+
+var DefaultTheme = Theme{
+	Resident:       Green,
+	Premigrated:    Yellow,
+	Migrated:       Red,
+	ResidentBg:     "\x1b[000042m",
+	PremigratedBg:  "\x1b[000043m",
+	MigratedBg:     "\x1b[000041m",
+	TooLarge:       BlinkingRedBackground,
+	Directory:      Blue,
+	Symlink:        LightBlue,
+	Orphan:         Orphan,
+	DirResident:    BoldGreen,
+	DirPremigrated: BoldYellow,
+	DirMigrated:    BoldRed,
+	DirMixed:       BoldMagenta,
+	Precedence:     PrecedenceState,
+}
+
+// Colorblind safe palette based upon Okabe and Ito's "Color Universal Design". Avoids telling states apart by red vs green
+var ColorblindTheme = Theme{
+	Resident:       SGR("38;5;117"), // sky blue
+	Premigrated:    SGR("38;5;221"), // yellow
+	Migrated:       SGR("38;5;166"), // vermillion
+	ResidentBg:     SGR("48;5;117"),
+	PremigratedBg:  SGR("48;5;221"),
+	MigratedBg:     SGR("48;5;166"),
+	TooLarge:       SGR("07;01;38;5;166"),
+	Directory:      SGR("01;34"),
+	Symlink:        LightBlue,
+	Orphan:         SGR("07;38;5;166"),
+	DirResident:    SGR("01;04;38;5;117"),
+	DirPremigrated: SGR("01;04;38;5;221"),
+	DirMigrated:    SGR("01;04;38;5;166"),
+	DirMixed:       SGR("01;04;38;5;175"), // reddish purple
+	Precedence:     PrecedenceState,
+}
+
+// Palettes that can be selected with config.Palette or palette= in a theme file
+var Palettes = map[string]Theme{
+	"default":    DefaultTheme,
+	"colorblind": ColorblindTheme,
+}
+
+// Theme file keys and the color they set
+func (t *Theme) fields() map[string]*Color {
+	return map[string]*Color{
+		"resident":        &t.Resident,
+		"premigrated":     &t.Premigrated,
+		"migrated":        &t.Migrated,
+		"resident-bg":     &t.ResidentBg,
+		"premigrated-bg":  &t.PremigratedBg,
+		"migrated-bg":     &t.MigratedBg,
+		"too-large":       &t.TooLarge,
+		"directory":       &t.Directory,
+		"symlink":         &t.Symlink,
+		"orphan":          &t.Orphan,
+		"dir-resident":    &t.DirResident,
+		"dir-premigrated": &t.DirPremigrated,
+		"dir-migrated":    &t.DirMigrated,
+		"dir-mixed":       &t.DirMixed,
+	}
+}
+
+// Apply theme files on top of t. Each line is key=SGR codes (e.g. "migrated=01;31"), precedence=state|background|lscolors
+// or palette=NAME to start over from one of Palettes; # starts a comment. Files that don't exist are skipped so site and user themes are optional.
+// Lines and files that can't be used are skipped as well, so the theme returned is always usable; the error is about the first of them
+func LoadTheme(t Theme, paths ...string) (Theme, error) {
+	var first error
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		err = t.parse(bufio.NewScanner(f), path)
+		f.Close()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return t, first
+}
+
+// Apply every usable line of a theme file, skipping the others. Returns an error about the first line skipped
+func (t *Theme) parse(scanner *bufio.Scanner, path string) error {
+	var first error
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if err := t.parseLine(scanner.Text()); err != nil && first == nil {
+			first = fmt.Errorf("%s:%d: %s", path, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return first
+}
+
+func (t *Theme) parseLine(line string) error {
+	line, _, _ = strings.Cut(line, "#")
+	line = strings.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	key, value, found := strings.Cut(line, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !found {
+		return fmt.Errorf("expected key=value")
+	}
+	switch key {
+	case "palette":
+		palette, ok := Palettes[value]
+		if !ok {
+			return fmt.Errorf("unknown palette %q", value)
+		}
+		palette.Precedence = t.Precedence
+		*t = palette
+		return nil
+	case "precedence":
+		if p := Precedence(value); !p.Valid() {
+			return fmt.Errorf("unknown precedence %q", value)
+		}
+		t.Precedence = Precedence(value)
+		return nil
+	}
+	color, ok := t.fields()[key]
+	if !ok {
+		return fmt.Errorf("unknown theme key %q", key)
+	}
+	if !ValidSGR(value) {
+		return fmt.Errorf("%s=%s: expected SGR codes such as 01;31", key, value)
+	}
+	*color = SGR(value)
+	return nil
+}
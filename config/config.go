@@ -1,8 +1,7 @@
 package config
 
 import (
-	"math"
-	"runtime"
+	"time"
 )
 
 type XAttr int
@@ -14,14 +13,107 @@ var (
 	MaxFileSizeGB int64 = 19450
 	// Disable stack trace upon failure
 	SuppressStackTrace = true
-	// Maximum number of GoRoutines to launch when statting files
-	MaxGoRoutines = int(math.Ceil(float64(runtime.NumCPU() / 2)))
-	// Always use maximum number of go routines; never try and minimize based upon directory size
+	// Most files looked up at once across a whole run. Below that the number adapts to how fast the metadata
+	// servers answer, see StatTargetLatency
+	MaxGoRoutines = 32
+	// Files looked up at once at the start of a run
+	StatStartGoRoutines = 4
+	// Lookups slower than this halve the number of files looked up at once; faster ones slowly raise it
+	StatTargetLatency = 50 * time.Millisecond
+	// Most files looked up per second across a whole run. 0 means no limit
+	StatRateLimit = 0.0
+	// Always look up MaxGoRoutines files at once instead of adapting to the latency
 	AlwaysUseMaxGoRoutines = false
 	//Hide the debug flag options from --help
 	HideDebugFlags = true
 	// Disables checking the size of the file against MaxFileSizeGB
 	DisableSizeChecking = false
+	// Names of DMAPI attributes holding a Unix timestamp (e.g. when the file was migrated) that `gls :stat` should decode.
+	// These vary between Spectrum Archive versions so none are assumed by default
+	HsmTimestampAttrs = []string{}
+	// How many directory levels --dir-state descends into when working out the state of a directory's contents
+	DirStateMaxDepth = 3
+	// Total time --dir-state may spend per listing. Directories not finished in time are marked as partial
+	DirStateTimeBudget = 2 * time.Second
+	// Color palette for storage states: "default" (green/yellow/red) or "colorblind" (blue/yellow/vermillion)
+	Palette = "default"
+	// Site wide theme file for the storage state colors. Users can add their own in ~/.config/gls/theme or $GLS_THEME
+	SiteThemeFile = "/etc/gls/theme"
+	// Use the file type and extension colors from LS_COLORS for files without a storage state
+	UseLSColors = true
+	// How storage state colors are combined with LS_COLORS: "state" (state color wins), "background" (state is the
+	// background color) or "lscolors" (LS_COLORS wins when it has a type or extension entry). Theme files can override this
+	StateColorPrecedence = "state"
+	// Number of tape copies the site's migration policy keeps of each file. Files with fewer copies are flagged by --pools
+	MinTapeCopies = 2
+	// Site migration policy explained by --why. MaxFileSizeGB is the maximum size. Files smaller than this many bytes are never migrated
+	PolicyMinSize int64 = 0
+	// Path prefixes (e.g. fileset junctions) that are never migrated
+	PolicyExcludePaths = []string{}
+	// File name patterns that are never migrated, e.g. "*.tmp"
+	PolicyExcludePatterns = []string{}
+	// Files are migrated once they haven't been accessed for this long. 0 migrates files regardless of age
+	PolicyMinAge time.Duration = 0
+	// Measure PolicyMinAge from the "access" or "modification" time
+	PolicyAgeBy = "access"
+	// mmapplypolicy rule file --why evaluates to predict which resident files the next policy run migrates.
+	// Only a subset of the language is understood, see policy.ILMPolicy. Empty disables the prediction
+	PolicyFile = ""
+	// Fileset junction paths and the name of the fileset at each, e.g. "/gpfs/proj/scratch": "scratch", so FOR FILESET
+	// clauses in PolicyFile can be checked. Rules for a fileset never match files below none of these
+	PolicyFilesets = map[string]string{}
+	// Parameters used by `gls :estimate` to work out how long a recall takes. Time for a drive to load and mount a tape
+	TapeMountTime = 90 * time.Second
+	// Average time to locate a file on a mounted tape
+	TapeSeekTime = 30 * time.Second
+	// Sustained read rate of a single tape drive in MB/s
+	TapeDriveMBps = 300
+	// Number of tape drives available for recalls
+	TapeDrives = 4
+	// Unix socket of the glsd caching daemon. gls asks it for storage states when it is running and looks them up
+	// itself otherwise. Empty disables the daemon in gls and glsd alike
+	DaemonSocket = "/run/glsd/glsd.sock"
+	// How long glsd trusts a cached state when the file's inode, size, mtime and ctime haven't changed. Recalls and
+	// migrations don't always change the ctime, so this bounds how stale an answer can be
+	DaemonCacheTTL = 5 * time.Minute
+	// Maximum number of files glsd keeps in its cache
+	DaemonCacheEntries = 1000000
+	// Maximum number of files glsd looks up at once across all clients
+	DaemonWorkers = 16
+	// Default of --cache: off, read or refresh. The per-user cache of storage states in $XDG_CACHE_HOME/gls is for
+	// sites without glsd; cached states are marked in listings since they weren't verified
+	CacheMode = "off"
+	// Default of --max-age, how old a cached state may be before it is looked up again. Like DaemonCacheTTL, since
+	// premigrating a file changes nothing the cache can see
+	CacheMaxAge = 5 * time.Minute
+	// Maximum number of files per directory kept in a user's cache
+	CacheEntries = 1000000
+	// Address `gls :serve` listens on. Keep it on localhost behind the portal's proxy unless every host that can
+	// reach it may see the files below ServeRoots
+	ServeListen = "localhost:8390"
+	// Paths `gls :serve` answers for. Empty means GpfsRoots
+	ServeRoots = []string{}
+	// Longest a `gls :serve` request may take
+	ServeTimeout = 30 * time.Second
+	// Requests `gls :serve` works on at once. Each looks up to MaxGoRoutines files at a time
+	ServeMaxRequests = 8
+	// Directories `gls :exporter` reports on, each with its own metrics. Empty means GpfsRoots
+	ExporterDirs = []string{}
+	// Address `gls :exporter` serves /metrics on. The metrics name owners and their usage, so only listen on other
+	// interfaces, e.g. ":9390", if every host that can reach it may see them
+	ExporterListen = "localhost:9390"
+	// Longest a scrape of /metrics may take to send its request and read the answer
+	ExporterTimeout = 30 * time.Second
+	// Time between the starts of two scans by `gls :exporter`. Walking a project directory isn't cheap
+	ExporterInterval = time.Hour
+	// Columns written by --format=csv and --format=tsv when --columns isn't given
+	DefaultColumns = []string{"path", "size", "owner", "group", "mtime", "state"}
+	// Named templates usable as --format-template=NAME. See ls.Entry for the available fields; tabs separate aligned columns
+	FormatTemplates = map[string]string{
+		"state": "{{.State}}\t{{.Path}}",
+		"tape":  "{{human .Size}}\t{{.State}}\t{{join .TapeIDs \",\"}}\t{{.Display}}",
+		"owner": "{{.Owner}}\t{{.Group}}\t{{.State}}\t{{.Display}}",
+	}
 
 	// Customize the following based upon the return codes for attr_check.cpp
 	// If a non default attr_check is used, these should be changed, else DO NOT TOUCH
@@ -33,6 +125,41 @@ var (
 	Ret0Hint string = "Indicates a file that is resident on disk"
 	Ret1Hint string = "Indicates a file that has been premigrated (e.g. resident on both tape and disk)"
 	Ret2Hint string = "Indicates a file that has been migrated to tape"
+	// Text used for symbolic links whose target doesn't exist when using --no-color
+	BrokenLinkStr string = "Broken link"
+	// Text added to entries whose storage state changed in --watch mode when colors are off
+	ChangedStr string = "Changed"
+	// Text added to entries whose storage state came from the cache without being looked up (see --cache)
+	CachedStr string = "Cached"
+	// Text used for files too large to ever be migrated when using --no-color
+	TooLargeStr string = "TOO LARGE TO MIGRATE"
+	// *Symbol is the single character shown in its own column in front of names with --indicator=symbol
+	Ret0Symbol           string = "d"
+	Ret1Symbol           string = "b"
+	Ret2Symbol           string = "t"
+	TooLargeSymbol       string = "!"
+	BrokenLinkSymbol     string = "?"
+	DirResidentSymbol    string = "D"
+	DirPremigratedSymbol string = "B"
+	DirMigratedSymbol    string = "T"
+	DirMixedSymbol       string = "M"
+	// *Suffix is appended to names with --indicator=suffix. Like the characters of ls -F they are neither letters
+	// nor digits, so they can't be taken for the end of a name
+	Ret0Suffix           string = "+"
+	Ret1Suffix           string = "~"
+	Ret2Suffix           string = "^"
+	TooLargeSuffix       string = "!"
+	BrokenLinkSuffix     string = "?"
+	DirResidentSuffix    string = "+/"
+	DirPremigratedSuffix string = "~/"
+	DirMigratedSuffix    string = "^/"
+	DirMixedSuffix       string = "&/"
+	// Dir*Str is the text used for directories with --dir-state and --no-color
+	DirEmptyStr       string = "No files"
+	DirResidentStr    string = "All resident"
+	DirPremigratedStr string = "All premigrated"
+	DirMigratedStr    string = "All migrated"
+	DirMixedStr       string = "Mixed"
+	// For directories the time or depth budget ran out on before any file was checked
+	DirNotCheckedStr string = "Not checked"
 )
-
-
@@ -0,0 +1,193 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// How long gls waits for glsd before looking files up itself
+const timeout = 30 * time.Second
+
+// A storage.Backend asking glsd. Files glsd can't answer for, and every file once the connection fails, are looked
+// up with the fallback backend instead
+type Client struct {
+	fallback storage.Backend
+
+	// Held for a whole round trip, since glsd answers the requests on a connection in order
+	wire    sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+
+	mu sync.Mutex
+	// Answers of the last Prefetch by absolute path. Those with an error are looked up with the fallback. Nothing
+	// else is kept, so a file looked up again is asked about again
+	answers map[string]known
+}
+
+type known struct {
+	Answer
+	// Includes the attributes and pool
+	pools bool
+}
+
+// Connect to glsd at socket. Fails straight away when glsd isn't running, so callers can use fallback directly
+func Dial(socket string, fallback storage.Backend) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 64<<20)
+	return &Client{
+		fallback: fallback,
+		conn:     conn,
+		scanner:  scanner,
+		answers:  make(map[string]known),
+	}, nil
+}
+
+func (c *Client) Close() error {
+	c.wire.Lock()
+	defer c.wire.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Send one request and wait for the answers. Must hold c.wire
+func (c *Client) query(req Request) ([]Answer, error) {
+	if c.conn == nil {
+		return nil, errors.New("not connected to glsd")
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(append(out, '\n')); err != nil {
+		return nil, c.disconnect(err)
+	}
+	if !c.scanner.Scan() {
+		err := c.scanner.Err()
+		if err == nil {
+			err = errors.New("glsd closed the connection")
+		}
+		return nil, c.disconnect(err)
+	}
+	var resp Response
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return nil, c.disconnect(err)
+	}
+	if len(resp.Error) != 0 {
+		return nil, errors.New("glsd: " + resp.Error)
+	}
+	if len(resp.Results) != len(req.Paths) {
+		return nil, c.disconnect(errors.New("glsd answered the wrong number of paths"))
+	}
+	return resp.Results, nil
+}
+
+// Give up on glsd for the rest of the run
+func (c *Client) disconnect(err error) error {
+	log.Debug().Msgf("Lost the connection to glsd, looking files up directly: %s", err)
+	c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// glsd resolves paths in its own working directory, so they are sent absolute
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Send req with c.wire held
+func (c *Client) ask(req Request) ([]Answer, error) {
+	c.wire.Lock()
+	defer c.wire.Unlock()
+	return c.query(req)
+}
+
+// Ask glsd about all paths in as few requests as possible. Later lookups of these paths are answered from memory
+// until the next Prefetch, which forgets them, so Prefetch(nil, false) drops them
+func (c *Client) Prefetch(paths []string, pools bool) {
+	answers := make(map[string]known, len(paths))
+	c.mu.Lock()
+	c.answers = answers
+	c.mu.Unlock()
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		abs[i] = absPath(path)
+	}
+	for start := 0; start < len(abs); start += MaxBatch {
+		end := start + MaxBatch
+		if end > len(abs) {
+			end = len(abs)
+		}
+		batch, err := c.ask(Request{Paths: abs[start:end], Pools: pools})
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		for i, a := range batch {
+			answers[abs[start+i]] = known{a, pools}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// An answer for path from the last Prefetch
+func (c *Client) known(path string, pools bool) (known, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k, ok := c.answers[path]
+	return k, ok && (k.pools || !pools || len(k.Error) != 0)
+}
+
+// The answer for path from Prefetch or glsd. ok is false when the fallback should be asked instead
+func (c *Client) answer(path string, pools bool) (a Answer, ok bool) {
+	path = absPath(path)
+	if k, ok := c.known(path, pools); ok {
+		return k.Answer, len(k.Error) == 0
+	}
+	answers, err := c.ask(Request{Paths: []string{path}, Pools: pools})
+	if err != nil {
+		return Answer{}, false
+	}
+	return answers[0], len(answers[0].Error) == 0
+}
+
+func (c *Client) State(path string) (storage.State, error) {
+	if a, ok := c.answer(path, false); ok {
+		return a.State, nil
+	}
+	return c.fallback.State(path)
+}
+
+func (c *Client) Attributes(path string) (string, error) {
+	if a, ok := c.answer(path, true); ok {
+		return a.Attributes, nil
+	}
+	return c.fallback.Attributes(path)
+}
+
+func (c *Client) Pool(path string) (string, error) {
+	if a, ok := c.answer(path, true); ok {
+		return a.Pool, nil
+	}
+	return c.fallback.Pool(path)
+}
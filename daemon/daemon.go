@@ -0,0 +1,314 @@
+// Package daemon is glsd, a cache of storage states shared by every gls on a machine, and the client gls uses to
+// talk to it. The protocol is one JSON Request per line over a Unix socket, each answered by one JSON Response line.
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Most paths a client may send in one request. The client splits larger batches
+const MaxBatch = 10000
+
+// Returned for files the client isn't allowed to see. The client looks those up itself
+var ErrPermission = errors.New("permission denied")
+
+type Request struct {
+	Paths []string `json:"paths"`
+	// Also return the HSM attributes and the storage pool
+	Pools bool `json:"pools,omitempty"`
+}
+
+// Results are in the same order as Request.Paths
+type Response struct {
+	Results []Answer `json:"results"`
+	// Set instead of Results when the whole request failed
+	Error string `json:"error,omitempty"`
+}
+
+type Answer struct {
+	State      storage.State `json:"state"`
+	Attributes string        `json:"attributes,omitempty"`
+	Pool       string        `json:"pool,omitempty"`
+	// The lookup failed and the other fields are meaningless
+	Error string `json:"error,omitempty"`
+}
+
+// What a cached answer is only valid for. The inode generation would also catch reused inodes, but reading it
+// costs an open per file, which is what the cache is there to save. A reused inode gets a new ctime anyway
+type key struct {
+	Dev, Ino     uint64
+	Size         int64
+	Mtime, Ctime syscall.Timespec
+}
+
+type entry struct {
+	key     key
+	fetched time.Time
+	answer  Answer
+	// The answer includes the attributes and pool
+	pools bool
+}
+
+// Answers requests from the cache, looking up files with Backend when they aren't cached or have changed
+type Server struct {
+	Backend storage.Backend
+	// How long an unchanged file's answer is trusted
+	TTL time.Duration
+	// Maximum number of cached files
+	Entries int
+	// Maximum number of lookups in flight across all connections
+	Workers int
+
+	mu    sync.Mutex
+	cache map[string]entry
+	slots chan struct{}
+}
+
+// A Server with an empty cache looking files up with backend
+func NewServer(backend storage.Backend, ttl time.Duration, entries, workers int) *Server {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Server{
+		Backend: backend,
+		TTL:     ttl,
+		Entries: entries,
+		Workers: workers,
+		cache:   make(map[string]entry),
+		slots:   make(chan struct{}, workers),
+	}
+}
+
+// Listen on a Unix socket at path, replacing a stale socket left behind by a previous glsd. Everyone may connect;
+// answers are limited to the files each client can read (see allowed)
+func Listen(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("glsd is already running on " + path)
+	}
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return l, os.Chmod(path, 0666)
+}
+
+// Answer connections until l is closed
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// The user at the other end of a connection
+type peer struct {
+	uid  uint32
+	gids map[uint32]bool
+}
+
+func peerOf(conn net.Conn) (peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peer{}, errors.New("not a Unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return peer{}, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return peer{}, err
+	}
+	p := peer{uid: cred.Uid, gids: map[uint32]bool{cred.Gid: true}}
+	if u, err := user.LookupId(strconv.Itoa(int(cred.Uid))); err == nil {
+		if gids, err := u.GroupIds(); err == nil {
+			for _, gid := range gids {
+				if n, err := strconv.ParseUint(gid, 10, 32); err == nil {
+					p.gids[uint32(n)] = true
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	p, err := peerOf(conn)
+	if err != nil {
+		log.Debug().Msgf("Unable to identify client: %s", err)
+		return
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 64<<20)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = err.Error()
+		} else if len(req.Paths) > MaxBatch {
+			resp.Error = "more than " + strconv.Itoa(MaxBatch) + " paths"
+		} else {
+			resp.Results = s.answer(p, req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Look up every path in req with up to Workers lookups in flight
+func (s *Server) answer(p peer, req Request) []Answer {
+	answers := make([]Answer, len(req.Paths))
+	dirs := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i, path := range req.Paths {
+		// The file that was checked is the one looked up, even if a link on the way is swapped meanwhile
+		real, ok := allowed(p, path, dirs)
+		if !ok {
+			answers[i] = Answer{State: storage.Unknown, Error: ErrPermission.Error()}
+			continue
+		}
+		s.slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer func() { <-s.slots; wg.Done() }()
+			answers[i] = s.lookup(path, req.Pools)
+		}(i, real)
+	}
+	wg.Wait()
+	return answers
+}
+
+// The cached answer for path if the file hasn't changed, otherwise a fresh one from the backend
+func (s *Server) lookup(path string, pools bool) Answer {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Answer{State: storage.Unknown, Error: err.Error()}
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	k := key{Dev: uint64(st.Dev), Ino: st.Ino, Size: st.Size, Mtime: st.Mtim, Ctime: st.Ctim}
+
+	s.mu.Lock()
+	e, ok := s.cache[path]
+	s.mu.Unlock()
+	if ok && e.key == k && time.Since(e.fetched) < s.TTL && (e.pools || !pools) {
+		return e.answer
+	}
+
+	a := Answer{}
+	if a.State, err = s.Backend.State(path); err == nil && pools {
+		if a.Attributes, err = s.Backend.Attributes(path); err == nil {
+			a.Pool, err = s.Backend.Pool(path)
+		}
+	}
+	if err != nil {
+		// Errors aren't cached so the next request tries again
+		return Answer{State: storage.Unknown, Error: err.Error()}
+	}
+	s.store(path, entry{key: k, fetched: time.Now(), answer: a, pools: pools})
+	return a
+}
+
+func (s *Server) store(path string, e entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[path]; !ok && len(s.cache) >= s.Entries {
+		s.evict()
+	}
+	s.cache[path] = e
+}
+
+// Make room by dropping expired entries, or a tenth of the cache when none have expired. Map order is random,
+// which makes the latter a random sample
+func (s *Server) evict() {
+	for path, e := range s.cache {
+		if time.Since(e.fetched) >= s.TTL {
+			delete(s.cache, path)
+		}
+	}
+	for path := range s.cache {
+		if len(s.cache) < s.Entries-s.Entries/10 {
+			break
+		}
+		delete(s.cache, path)
+	}
+}
+
+// Could p read path? Checks the search permission on every directory above the file and the read permission of
+// the file itself against p's user and groups, following symbolic links first. ACLs aren't considered: clients
+// refused here look the file up themselves. dirs remembers the directories already checked. Returns the path with
+// its links followed, which is what should be looked up
+func allowed(p peer, path string, dirs map[string]bool) (string, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// Missing files are reported as such to root, and as refused to everyone else
+		return path, p.uid == 0
+	}
+	if p.uid == 0 {
+		return real, true
+	}
+	for dir := filepath.Dir(real); ; dir = filepath.Dir(dir) {
+		ok, checked := dirs[dir]
+		if !checked {
+			ok = permitted(p, dir, 1)
+			dirs[dir] = ok
+		}
+		if !ok {
+			return "", false
+		}
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+	return real, permitted(p, real, 4)
+}
+
+// Does the mode of path grant p the permission bit (4 read, 2 write, 1 execute/search)?
+func permitted(p peer, path string, bit uint32) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	switch {
+	case st.Uid == p.uid:
+		return st.Mode>>6&bit != 0
+	case p.gids[st.Gid]:
+		return st.Mode>>3&bit != 0
+	}
+	return st.Mode&bit != 0
+}
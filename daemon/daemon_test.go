@@ -0,0 +1,285 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olcf/gls/storage"
+)
+
+// States by file name, counting the lookups
+type testBackend struct {
+	mu     sync.Mutex
+	states map[string]storage.State
+	calls  int
+}
+
+func (b *testBackend) State(path string) (storage.State, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	state, ok := b.states[filepath.Base(path)]
+	if !ok {
+		return storage.Unknown, errors.New("no attributes")
+	}
+	return state, nil
+}
+
+func (b *testBackend) Attributes(path string) (string, error) {
+	return "IBMTPS|1 JD0147JD@primary@lib1", nil
+}
+
+func (b *testBackend) Pool(path string) (string, error) {
+	return "system", nil
+}
+
+func (b *testBackend) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls
+}
+
+// A running glsd answering from backend and the directory holding its socket and the files a, b and bad
+func testServer(t *testing.T, backend storage.Backend) (dir, socket string) {
+	// t.TempDir() paths can be longer than a Unix socket path may be
+	dir, err := os.MkdirTemp("", "glsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, name := range []string{"a", "b", "bad"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	socket = filepath.Join(dir, "glsd.sock")
+	l, err := Listen(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewServer(backend, time.Hour, 100, 4).Serve(l)
+	return dir, socket
+}
+
+func TestClient(t *testing.T) {
+	backend := &testBackend{states: map[string]storage.State{"a": storage.Migrated, "b": storage.Premigrated}}
+	dir, socket := testServer(t, backend)
+	fallback := &testBackend{states: map[string]storage.State{"bad": storage.Resident}}
+	c, err := Dial(socket, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	a, b, bad := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "bad")
+	c.Prefetch([]string{a, b, bad}, false)
+	for path, want := range map[string]storage.State{a: storage.Migrated, b: storage.Premigrated, bad: storage.Resident} {
+		if have, err := c.State(path); err != nil || have != want {
+			t.Fatalf("daemon.Client.State(%v) = %v, %v; want %v", path, have, err, want)
+		}
+	}
+	if have := fallback.count(); have != 1 {
+		t.Fatalf("fallback lookups = %v; want %v", have, 1)
+	}
+	if have, err := c.Pool(a); err != nil || have != "system" {
+		t.Fatalf("daemon.Client.Pool(%v) = %v, %v; want %v", a, have, err, "system")
+	}
+}
+
+func TestCache(t *testing.T) {
+	backend := &testBackend{states: map[string]storage.State{"a": storage.Migrated}}
+	dir, socket := testServer(t, backend)
+	a := filepath.Join(dir, "a")
+	state := func() storage.State {
+		// A new client each time so its own memory doesn't answer
+		c, err := Dial(socket, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		s, err := c.State(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	state()
+	state()
+	if have := backend.count(); have != 1 {
+		t.Fatalf("backend lookups after asking twice = %v; want %v", have, 1)
+	}
+
+	// Recalling the file changes it
+	backend.mu.Lock()
+	backend.states["a"] = storage.Resident
+	backend.mu.Unlock()
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if have := state(); have != storage.Resident {
+		t.Fatalf("state after a change = %v; want %v", have, storage.Resident)
+	}
+	if have := backend.count(); have != 2 {
+		t.Fatalf("backend lookups after a change = %v; want %v", have, 2)
+	}
+}
+
+func TestClientChanges(t *testing.T) {
+	backend := &testBackend{states: map[string]storage.State{"a": storage.Migrated}}
+	dir, socket := testServer(t, backend)
+	c, err := Dial(socket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a := filepath.Join(dir, "a")
+	recall := func() {
+		backend.mu.Lock()
+		backend.states["a"] = storage.Resident
+		backend.mu.Unlock()
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(a, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files that weren't prefetched are asked about every time
+	if have, err := c.State(a); err != nil || have != storage.Migrated {
+		t.Fatalf("daemon.Client.State(%v) = %v, %v; want %v", a, have, err, storage.Migrated)
+	}
+	recall()
+	if have, err := c.State(a); err != nil || have != storage.Resident {
+		t.Fatalf("daemon.Client.State(%v) after a recall = %v, %v; want %v", a, have, err, storage.Resident)
+	}
+
+	// Prefetched answers last until the next Prefetch
+	c.Prefetch([]string{a}, false)
+	backend.mu.Lock()
+	backend.states["a"] = storage.Migrated
+	backend.mu.Unlock()
+	if have, _ := c.State(a); have != storage.Resident {
+		t.Fatalf("daemon.Client.State(%v) after Prefetch = %v; want %v", a, have, storage.Resident)
+	}
+	later := time.Now().Add(2 * time.Minute)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	c.Prefetch(nil, false)
+	if have, err := c.State(a); err != nil || have != storage.Migrated {
+		t.Fatalf("daemon.Client.State(%v) after Prefetch(nil) = %v, %v; want %v", a, have, err, storage.Migrated)
+	}
+	if len(c.answers) != 0 {
+		t.Fatalf("daemon.Client kept %v answers; want %v", len(c.answers), 0)
+	}
+}
+
+func TestDialNotRunning(t *testing.T) {
+	if _, err := Dial(filepath.Join(t.TempDir(), "glsd.sock"), nil); err == nil {
+		t.Fatalf("daemon.Dial() without glsd = nil; want an error")
+	}
+}
+
+func TestListenRunning(t *testing.T) {
+	_, socket := testServer(t, &testBackend{})
+	if _, err := Listen(socket); err == nil {
+		t.Fatalf("daemon.Listen(%v) with glsd running = nil; want an error", socket)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	s := NewServer(&testBackend{}, time.Hour, 10, 1)
+	for i := 0; i < 25; i++ {
+		s.store(string(rune('a'+i)), entry{fetched: time.Now()})
+	}
+	if len(s.cache) > 10 {
+		t.Fatalf("cached entries = %v; want at most %v", len(s.cache), 10)
+	}
+	if _, ok := s.cache["y"]; !ok {
+		t.Fatalf("the entry stored last was evicted")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to hand files to other users")
+	}
+	dir, err := os.MkdirTemp("", "glsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files := []struct {
+		name     string
+		mode     os.FileMode
+		uid, gid int
+	}{
+		{"own", 0400, 1000, 0},
+		{"group", 0040, 0, 500},
+		{"other", 0004, 0, 0},
+		{"closed", 0700 | os.ModeDir, 0, 0},
+		{"closed/other", 0004, 0, 0},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if f.mode.IsDir() {
+			err = os.Mkdir(path, 0700)
+		} else {
+			err = os.WriteFile(path, nil, 0600)
+		}
+		if err == nil {
+			err = os.Chown(path, f.uid, f.gid)
+		}
+		if err == nil {
+			err = os.Chmod(path, f.mode.Perm())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []struct{ name, target string }{{"to-other", "other"}, {"to-closed", "closed/other"}} {
+		if err := os.Symlink(link.target, filepath.Join(dir, link.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owner := peer{uid: 1000, gids: map[uint32]bool{1000: true}}
+	member := peer{uid: 2000, gids: map[uint32]bool{500: true}}
+	tests := []struct {
+		p    peer
+		name string
+		want bool
+	}{
+		{owner, "own", true},
+		{member, "own", false},
+		{member, "group", true},
+		{owner, "group", false},
+		{owner, "other", true},
+		// The file is readable but its directory isn't searchable
+		{owner, "closed/other", false},
+		{owner, "to-other", true},
+		{owner, "to-closed", false},
+		{peer{uid: 0}, "to-closed", true},
+		{owner, "missing", false},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		real, have := allowed(test.p, path, make(map[string]bool))
+		if have != test.want {
+			t.Fatalf("daemon.allowed(%v, %s) = %t; want %t", test.p, test.name, have, test.want)
+		}
+		// Links are looked up by their target, so swapping them after the check changes nothing
+		if want, _ := filepath.EvalSymlinks(path); have && real != want {
+			t.Fatalf("daemon.allowed(%v, %s) = %s; want %s", test.p, test.name, real, want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package exporter is `gls :exporter`: it walks configured directories with a storage.Client and publishes the files
+// and bytes in each storage state per directory and owner as Prometheus metrics, either over HTTP or as a file for
+// node_exporter's textfile collector. The text exposition format is written by hand, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Files looked up at once. Bounds memory on big trees while keeping every worker busy
+const batchSize = 1000
+
+// Files and bytes of one owner in one state
+type Count struct {
+	Files int64
+	Bytes int64
+}
+
+type group struct {
+	Owner string
+	State string
+}
+
+// The result of walking one directory
+type Scan struct {
+	Dir    string
+	Counts map[group]Count
+	// Files and directories that couldn't be read or looked up
+	Errors   int
+	Duration time.Duration
+	Finished time.Time
+}
+
+// Scans Dirs and keeps the last complete scan of each. Safe for concurrent use
+type Exporter struct {
+	Client *storage.Client
+	Dirs   []string
+
+	mu    sync.Mutex
+	scans map[string]*Scan
+	// Since the exporter started, so they can be Prometheus counters
+	errors     map[string]int
+	scansTotal map[string]int
+	owners     map[uint32]string
+}
+
+// An Exporter walking dirs with client
+func New(client *storage.Client, dirs []string) *Exporter {
+	return &Exporter{
+		Client:     client,
+		Dirs:       dirs,
+		scans:      make(map[string]*Scan),
+		errors:     make(map[string]int),
+		scansTotal: make(map[string]int),
+		owners:     make(map[uint32]string),
+	}
+}
+
+// Name of the user with uid, or the uid itself when it has none
+func (e *Exporter) owner(uid uint32) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name, ok := e.owners[uid]
+	if !ok {
+		name = strconv.FormatUint(uint64(uid), 10)
+		if u, err := user.LookupId(name); err == nil {
+			name = u.Username
+		}
+		e.owners[uid] = name
+	}
+	return name
+}
+
+// Walk every directory once. A directory whose walk is cut short by ctx keeps its previous results
+func (e *Exporter) Scan(ctx context.Context) {
+	for _, dir := range e.Dirs {
+		s := e.scan(ctx, dir)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Debug().Msgf("Scanned %s in %s with %d errors", dir, s.Duration, s.Errors)
+		e.mu.Lock()
+		e.scans[dir] = s
+		e.errors[dir] += s.Errors
+		e.scansTotal[dir]++
+		e.mu.Unlock()
+	}
+}
+
+func (e *Exporter) scan(ctx context.Context, dir string) *Scan {
+	start := time.Now()
+	s := &Scan{Dir: dir, Counts: make(map[group]Count)}
+	// Owner of each file in the batch by path
+	batch := make(map[string]string)
+	flush := func() {
+		paths := make([]string, 0, len(batch))
+		for path := range batch {
+			paths = append(paths, path)
+		}
+		for r := range e.Client.StatMany(ctx, paths) {
+			if r.Err != nil {
+				s.Errors++
+				continue
+			}
+			g := group{Owner: batch[r.Entry.Path], State: r.Entry.State.String()}
+			c := s.Counts[g]
+			c.Files++
+			c.Bytes += r.Entry.Size
+			s.Counts[g] = c
+		}
+		batch = make(map[string]string)
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.Errors++
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			s.Errors++
+			return nil
+		}
+		owner := ""
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			owner = e.owner(st.Uid)
+		}
+		if batch[path] = owner; len(batch) == batchSize {
+			flush()
+		}
+		return nil
+	})
+	flush()
+	s.Finished = time.Now()
+	s.Duration = s.Finished.Sub(start)
+	return s
+}
+
+// Start a scan every interval until ctx is cancelled, starting straight away. A scan taking longer than interval
+// is followed by the next one right away rather than by every tick it missed
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		e.Scan(ctx)
+		select {
+		case <-ticker.C:
+			continue
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// A metric family being written
+type family struct {
+	name, help, kind string
+	samples          []string
+}
+
+func (f *family) add(labels []string, value string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escape(labels[i+1])+`"`)
+	}
+	f.samples = append(f.samples, f.name+"{"+strings.Join(pairs, ",")+"} "+value)
+}
+
+// Label values escape backslashes, double quotes and line feeds
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Write the metrics of the last complete scans in the Prometheus text format
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	files := &family{name: "gls_files", help: "Regular files by directory, owner and storage state", kind: "gauge"}
+	bytes := &family{name: "gls_bytes", help: "Bytes in regular files by directory, owner and storage state", kind: "gauge"}
+	duration := &family{name: "gls_scan_duration_seconds", help: "How long the last scan of the directory took", kind: "gauge"}
+	finished := &family{name: "gls_scan_timestamp_seconds", help: "When the last scan of the directory finished", kind: "gauge"}
+	errors := &family{name: "gls_scan_errors_total", help: "Files and directories that couldn't be read or looked up", kind: "counter"}
+	scans := &family{name: "gls_scans_total", help: "Completed scans of the directory", kind: "counter"}
+
+	e.mu.Lock()
+	for _, dir := range e.Dirs {
+		s, ok := e.scans[dir]
+		if !ok {
+			continue
+		}
+		groups := make([]group, 0, len(s.Counts))
+		for g := range s.Counts {
+			groups = append(groups, g)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i].Owner != groups[j].Owner {
+				return groups[i].Owner < groups[j].Owner
+			}
+			return groups[i].State < groups[j].State
+		})
+		for _, g := range groups {
+			labels := []string{"directory", dir, "owner", g.Owner, "state", g.State}
+			files.add(labels, strconv.FormatInt(s.Counts[g].Files, 10))
+			bytes.add(labels, strconv.FormatInt(s.Counts[g].Bytes, 10))
+		}
+		labels := []string{"directory", dir}
+		duration.add(labels, strconv.FormatFloat(s.Duration.Seconds(), 'f', -1, 64))
+		finished.add(labels, strconv.FormatInt(s.Finished.Unix(), 10))
+		errors.add(labels, strconv.Itoa(e.errors[dir]))
+		scans.add(labels, strconv.Itoa(e.scansTotal[dir]))
+	}
+	e.mu.Unlock()
+
+	var n int64
+	for _, f := range []*family{files, bytes, duration, finished, errors, scans} {
+		if len(f.samples) == 0 {
+			continue
+		}
+		m, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s\n", f.name, f.help, f.name, f.kind, strings.Join(f.samples, "\n"))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Serve the metrics, e.g. on /metrics
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteTo(w)
+}
+
+// Write the metrics to path for node_exporter's textfile collector. The file is replaced in one go so the
+// collector never reads half of it
+func (e *Exporter) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = e.WriteTo(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
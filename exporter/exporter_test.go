@@ -0,0 +1,87 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olcf/gls/fake"
+	"github.com/olcf/gls/storage"
+)
+
+const testFixture = `{
+	"files": {
+		"/a": {"size": 2, "state": "migrated"},
+		"/b": {"size": 5},
+		"/sub/c": {"size": 3, "state": "migrated"},
+		"/sub/bad": {"error": "no attributes"}
+	}
+}`
+
+func TestWriteTo(t *testing.T) {
+	fx, err := fake.Parse(strings.NewReader(testFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	backend, err := fx.Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(&storage.Client{Backend: backend, Roots: []string{dir}, Workers: 2}, []string{dir})
+	e.Scan(context.Background())
+	e.Scan(context.Background())
+
+	var out strings.Builder
+	if _, err := e.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	labels := `directory="` + dir + `",owner="` + me.Username + `"`
+	for _, want := range []string{
+		"# TYPE gls_files gauge\n",
+		`gls_files{` + labels + `,state="migrated"} 2` + "\n",
+		`gls_bytes{` + labels + `,state="migrated"} 5` + "\n",
+		`gls_files{` + labels + `,state="resident"} 1` + "\n",
+		`gls_bytes{` + labels + `,state="resident"} 5` + "\n",
+		"# TYPE gls_scan_errors_total counter\n",
+		// One error per scan
+		`gls_scan_errors_total{directory="` + dir + `"} 2` + "\n",
+		`gls_scans_total{directory="` + dir + `"} 2` + "\n",
+		`gls_scan_duration_seconds{directory="` + dir + `"} `,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("exporter.WriteTo() = \n%s\nwant it to contain %q", out.String(), want)
+		}
+	}
+
+	prom := filepath.Join(t.TempDir(), "gls.prom")
+	if err := e.WriteFile(prom); err != nil {
+		t.Fatal(err)
+	}
+	if have, err := os.ReadFile(prom); err != nil || string(have) != out.String() {
+		t.Fatalf("exporter.WriteFile() wrote %q, %v; want %q", have, err, out.String())
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/gpfs/proj", "/gpfs/proj"},
+		{`a"b`, `a\"b`},
+		{`a\b`, `a\\b`},
+		{"a\nb", `a\nb`},
+	}
+	for _, test := range tests {
+		if have := escape(test.in); have != test.want {
+			t.Fatalf("exporter.escape(%q) = %q; want %q", test.in, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+// Package fake stands in for GPFS in tests and demos. A fixture file describes a tree of files and their storage
+// state: Backend answers storage queries from it and Fs holds the tree in memory.
+package fake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/spf13/afero"
+)
+
+// File types in a fixture
+const (
+	TypeFile = "file"
+	TypeDir  = "dir"
+	TypeLink = "link"
+)
+
+// Links followed before giving up, like the kernel's ELOOP limit
+const maxSymlinkHops = 40
+
+// A file in a fixture. The zero value is an empty, resident file owned by root and modified at Fixture.Mtime
+type File struct {
+	// One of the Type* constants. Defaults to TypeFile
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	// Permission bits in octal, e.g. "0640". Defaults to 0644 for files, 0755 for directories and 0777 for links
+	Mode  string    `json:"mode"`
+	Mtime time.Time `json:"mtime"`
+	Atime time.Time `json:"atime"`
+	UID   uint32    `json:"uid"`
+	GID   uint32    `json:"gid"`
+	// What a TypeLink points to, relative to the link's directory unless absolute
+	Target string        `json:"target"`
+	State  storage.State `json:"state"`
+	Pool   string        `json:"pool"`
+	// Tape copies as VOLSER@POOL@LIBRARY
+	Tapes []string `json:"tapes"`
+	// Extra raw HSM attributes with '|' between them, see storage.Backend.Attributes
+	Attributes string `json:"attributes"`
+	// Every backend call for this file fails with this message
+	Error string `json:"error"`
+	// How long every backend call for this file takes, e.g. "20ms"
+	Latency string `json:"latency"`
+
+	latency time.Duration
+	mode    os.FileMode
+}
+
+// A tree of files by absolute path. Parent directories are created as needed
+type Fixture struct {
+	Mtime time.Time        `json:"mtime"`
+	Files map[string]*File `json:"files"`
+}
+
+// Read a fixture from a JSON file
+func Load(path string) (*Fixture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Read a JSON fixture and fill in the defaults
+func Parse(r io.Reader) (*Fixture, error) {
+	var fx Fixture
+	if err := json.NewDecoder(r).Decode(&fx); err != nil {
+		return nil, fmt.Errorf("fake: %w", err)
+	}
+	files := make(map[string]*File, len(fx.Files))
+	for path, f := range fx.Files {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("fake: %s: path is not absolute", path)
+		}
+		if f == nil {
+			f = &File{}
+		}
+		if len(f.Type) == 0 {
+			f.Type = TypeFile
+		}
+		perm := map[string]os.FileMode{TypeFile: 0644, TypeDir: 0755, TypeLink: 0777}
+		if _, ok := perm[f.Type]; !ok {
+			return nil, fmt.Errorf("fake: %s: unknown type %q", path, f.Type)
+		}
+		f.mode = perm[f.Type]
+		if len(f.Mode) != 0 {
+			mode, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil || mode > 0777 {
+				return nil, fmt.Errorf("fake: %s: bad mode %q", path, f.Mode)
+			}
+			f.mode = os.FileMode(mode)
+		}
+		if len(f.Latency) != 0 {
+			var err error
+			if f.latency, err = time.ParseDuration(f.Latency); err != nil {
+				return nil, fmt.Errorf("fake: %s: %w", path, err)
+			}
+		}
+		if f.Mtime.IsZero() {
+			f.Mtime = fx.Mtime
+		}
+		if f.Atime.IsZero() {
+			f.Atime = f.Mtime
+		}
+		files[filepath.Clean(path)] = f
+	}
+	fx.Files = files
+	return &fx, nil
+}
+
+// path with the symbolic links in the fixture followed
+func (fx *Fixture) follow(path string) (string, error) {
+	path = filepath.Clean(path)
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		f, ok := fx.Files[path]
+		if !ok || f.Type != TypeLink {
+			return path, nil
+		}
+		path = target(path, f)
+	}
+	return "", &fs.PathError{Op: "stat", Path: path, Err: errors.New("too many links")}
+}
+
+// The entry for path with its symbolic links followed
+func (fx *Fixture) resolve(path string) (*File, error) {
+	path, err := fx.follow(path)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := fx.Files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// Absolute path of a link's target
+func target(path string, f *File) string {
+	if filepath.IsAbs(f.Target) {
+		return filepath.Clean(f.Target)
+	}
+	return filepath.Join(filepath.Dir(path), f.Target)
+}
+
+// A storage.Backend answering from the fixture. Files that aren't in the fixture are an error
+func (fx *Fixture) Backend() storage.Backend {
+	return backend{fx: fx}
+}
+
+type backend struct {
+	fx *Fixture
+	// Where Create put the tree, stripped from the paths asked about
+	root string
+}
+
+// The file at path after its latency, or the error the fixture asks for
+func (b backend) lookup(path string) (*File, error) {
+	if len(b.root) != 0 {
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		path = filepath.Join("/", rel)
+	}
+	f, err := b.fx.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(f.latency)
+	if len(f.Error) != 0 {
+		return nil, errors.New(f.Error)
+	}
+	return f, nil
+}
+
+func (b backend) State(path string) (storage.State, error) {
+	f, err := b.lookup(path)
+	if err != nil {
+		return storage.Unknown, err
+	}
+	return f.State, nil
+}
+
+func (b backend) Attributes(path string) (string, error) {
+	f, err := b.lookup(path)
+	if err != nil {
+		return "", err
+	}
+	var attrs []string
+	if len(f.Attributes) != 0 {
+		attrs = append(attrs, f.Attributes)
+	}
+	if len(f.Tapes) != 0 {
+		attrs = append(attrs, "IBMTPS", fmt.Sprintf("%d %s", len(f.Tapes), strings.Join(f.Tapes, ":")))
+	}
+	return strings.Join(attrs, "|"), nil
+}
+
+func (b backend) Pool(path string) (string, error) {
+	f, err := b.lookup(path)
+	if err != nil {
+		return "", err
+	}
+	return f.Pool, nil
+}
+
+// Create the fixture's tree below root on the real filesystem, for code that walks it with the os package, and
+// return a Backend answering for the files there. Files are sparse and belong to whoever runs Create, and absolute
+// link targets point below root as well
+func (fx *Fixture) Create(root string) (storage.Backend, error) {
+	var paths []string
+	for path := range fx.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := fx.Files[path]
+		real := filepath.Join(root, path)
+		err := os.MkdirAll(filepath.Dir(real), 0755)
+		switch {
+		case err != nil:
+		case f.Type == TypeDir:
+			err = os.MkdirAll(real, 0755)
+		case f.Type == TypeLink && filepath.IsAbs(f.Target):
+			err = os.Symlink(filepath.Join(root, f.Target), real)
+		case f.Type == TypeLink:
+			err = os.Symlink(f.Target, real)
+		default:
+			if err = os.WriteFile(real, nil, f.mode); err == nil {
+				err = os.Truncate(real, f.Size)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Deepest first, so directories are only closed off once everything below them is done
+	for i := len(paths) - 1; i >= 0; i-- {
+		f, real := fx.Files[paths[i]], filepath.Join(root, paths[i])
+		if f.Type == TypeLink {
+			continue
+		}
+		if err := os.Chtimes(real, f.Atime, f.Mtime); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(real, f.mode); err != nil {
+			return nil, err
+		}
+	}
+	return backend{fx: fx, root: root}, nil
+}
+
+// The fixture's tree in memory. Supports symbolic links (afero.Lstater and afero.LinkReader) and reports the
+// fixture's owners and sizes through a *syscall.Stat_t like the OS does
+func (fx *Fixture) Fs() (afero.Fs, error) {
+	mem := afero.NewMemMapFs()
+	var paths []string
+	for path := range fx.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := fx.Files[path]
+		var err error
+		switch f.Type {
+		case TypeDir:
+			err = mem.MkdirAll(path, f.mode)
+		default:
+			// Links are empty files underneath so they show up in directory listings
+			if err = mem.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				err = afero.WriteFile(mem, path, nil, f.mode)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Parents created along the way get the fixture's time too
+	err := afero.Walk(mem, "/", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mtime, atime := fx.Mtime, fx.Mtime
+		if f, ok := fx.Files[path]; ok {
+			mtime, atime = f.Mtime, f.Atime
+		}
+		return mem.Chtimes(path, atime, mtime)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &memFs{Fs: mem, fx: fx}, nil
+}
+
+type memFs struct {
+	afero.Fs
+	fx *Fixture
+}
+
+// info of the file at path, named name like os.Stat names files after the path it was given
+func (m *memFs) info(name, path string, info fs.FileInfo) fs.FileInfo {
+	return fileInfo{FileInfo: info, name: filepath.Base(name), file: m.fx.Files[filepath.Clean(path)]}
+}
+
+func (m *memFs) LstatIfPossible(name string) (fs.FileInfo, bool, error) {
+	info, err := m.Fs.Stat(name)
+	if err != nil {
+		return nil, true, err
+	}
+	return m.info(name, name, info), true, nil
+}
+
+func (m *memFs) ReadlinkIfPossible(name string) (string, error) {
+	if f, ok := m.fx.Files[filepath.Clean(name)]; ok && f.Type == TypeLink {
+		return f.Target, nil
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func (m *memFs) Stat(name string) (fs.FileInfo, error) {
+	path, err := m.fx.follow(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := m.Fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return m.info(name, path, info), nil
+}
+
+func (m *memFs) Open(name string) (afero.File, error) {
+	f, err := m.Fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return dir{File: f, fs: m, path: name}, nil
+}
+
+// Lists directories with the fixture's file types and owners
+type dir struct {
+	afero.File
+	fs   *memFs
+	path string
+}
+
+func (d dir) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	for i, info := range infos {
+		path := filepath.Join(d.path, info.Name())
+		infos[i] = d.fs.info(path, path, info)
+	}
+	return infos, err
+}
+
+// The memory filesystem's FileInfo with the size, type and owner from the fixture
+type fileInfo struct {
+	fs.FileInfo
+	name string
+	file *File
+}
+
+func (f fileInfo) Name() string {
+	return f.name
+}
+
+func (f fileInfo) Size() int64 {
+	switch {
+	case f.file == nil || f.file.Type == TypeDir:
+		return f.FileInfo.Size()
+	case f.file.Type == TypeLink:
+		return int64(len(f.file.Target))
+	}
+	return f.file.Size
+}
+
+func (f fileInfo) Mode() fs.FileMode {
+	if f.file != nil && f.file.Type == TypeLink {
+		return fs.ModeSymlink | f.file.mode
+	}
+	return f.FileInfo.Mode()
+}
+
+func (f fileInfo) Sys() interface{} {
+	st := &syscall.Stat_t{
+		Mode:    uint32(f.Mode().Perm()),
+		Nlink:   1,
+		Size:    f.Size(),
+		Blksize: 4096,
+		Blocks:  (f.Size() + 511) / 512,
+		Mtim:    syscall.NsecToTimespec(f.ModTime().UnixNano()),
+		Atim:    syscall.NsecToTimespec(f.ModTime().UnixNano()),
+		Ctim:    syscall.NsecToTimespec(f.ModTime().UnixNano()),
+	}
+	if f.file != nil {
+		st.Uid, st.Gid = f.file.UID, f.file.GID
+		st.Atim = syscall.NsecToTimespec(f.file.Atime.UnixNano())
+	}
+	return st
+}
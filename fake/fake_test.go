@@ -0,0 +1,135 @@
+package fake
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/spf13/afero"
+)
+
+const testFixture = `{
+	"mtime": "2021-03-31T04:28:00Z",
+	"files": {
+		"/gpfs/proj/data.h5": {"size": 5000, "state": "migrated", "pool": "system", "tapes": ["JD0147JD@primary@lib1"], "uid": 1000},
+		"/gpfs/proj/slow": {"latency": "20ms", "state": "premigrated"},
+		"/gpfs/proj/bad": {"error": "Permission denied"},
+		"/gpfs/proj/latest": {"type": "link", "target": "data.h5"},
+		"/gpfs/proj/sub": {"type": "dir", "mode": "0700"}
+	}
+}`
+
+func TestParse(t *testing.T) {
+	for _, bad := range []string{
+		`{"files": {"relative": {}}}`,
+		`{"files": {"/a": {"type": "fifo"}}}`,
+		`{"files": {"/a": {"mode": "rwx"}}}`,
+		`{"files": {"/a": {"mode": "1777"}}}`,
+		`{"files": {"/a": {"latency": "soon"}}}`,
+		`{"files": {"/a": {"state": "lost"}}}`,
+	} {
+		if _, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Fatalf("fake.Parse(%s) = nil; want error", bad)
+		}
+	}
+}
+
+func TestBackend(t *testing.T) {
+	fx, err := Parse(strings.NewReader(testFixture))
+	if err != nil {
+		t.Fatalf("fake.Parse() = %s; want nil", err)
+	}
+	b := fx.Backend()
+	tests := map[string]storage.State{
+		"/gpfs/proj/data.h5": storage.Migrated,
+		"/gpfs/proj/latest":  storage.Migrated,
+		"/gpfs/proj/slow":    storage.Premigrated,
+	}
+	for path, want := range tests {
+		if have, err := b.State(path); err != nil || have != want {
+			t.Fatalf("fake.Backend.State(%s) = %s, %v; want %s", path, have, err, want)
+		}
+	}
+	for _, path := range []string{"/gpfs/proj/bad", "/gpfs/proj/missing"} {
+		if _, err := b.State(path); err == nil {
+			t.Fatalf("fake.Backend.State(%s) = nil; want error", path)
+		}
+	}
+
+	start := time.Now()
+	b.Pool("/gpfs/proj/slow")
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("fake.Backend.Pool(slow) took %s; want at least 20ms", elapsed)
+	}
+
+	attrs, _ := b.Attributes("/gpfs/proj/data.h5")
+	if copies := storage.ParseTapeCopies(attrs); len(copies) != 1 || copies[0].Volser != "JD0147JD" {
+		t.Fatalf("fake.Backend.Attributes(data.h5) = %q; want tape copy JD0147JD", attrs)
+	}
+}
+
+func TestFs(t *testing.T) {
+	fx, err := Parse(strings.NewReader(testFixture))
+	if err != nil {
+		t.Fatalf("fake.Parse() = %s; want nil", err)
+	}
+	fs, err := fx.Fs()
+	if err != nil {
+		t.Fatalf("fake.Fixture.Fs() = %s; want nil", err)
+	}
+
+	names, err := afero.ReadDir(fs, "/gpfs/proj")
+	if err != nil || len(names) != 5 {
+		t.Fatalf("afero.ReadDir(/gpfs/proj) = %v, %v; want 5 entries", names, err)
+	}
+	info, _, err := fs.(afero.Lstater).LstatIfPossible("/gpfs/proj/latest")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("fake.Fs.LstatIfPossible(latest) = %v, %v; want a link", info, err)
+	}
+	if target, err := fs.(afero.LinkReader).ReadlinkIfPossible("/gpfs/proj/latest"); err != nil || target != "data.h5" {
+		t.Fatalf("fake.Fs.ReadlinkIfPossible(latest) = %s, %v; want data.h5", target, err)
+	}
+
+	info, err = fs.Stat("/gpfs/proj/latest")
+	if err != nil || info.Size() != 5000 || info.Sys().(*syscall.Stat_t).Uid != 1000 {
+		t.Fatalf("fake.Fs.Stat(latest) = %v, %v; want data.h5 owned by 1000", info, err)
+	}
+	if !info.ModTime().Equal(fx.Mtime) {
+		t.Fatalf("fake.Fs.Stat(latest).ModTime() = %s; want %s", info.ModTime(), fx.Mtime)
+	}
+	if info, _ := fs.Stat("/gpfs/proj/sub"); !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Fatalf("fake.Fs.Stat(sub) = %v; want directory with mode 0700", info)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	fx, err := Parse(strings.NewReader(testFixture))
+	if err != nil {
+		t.Fatalf("fake.Parse() = %s; want nil", err)
+	}
+	root := t.TempDir()
+	b, err := fx.Create(root)
+	if err != nil {
+		t.Fatalf("fake.Fixture.Create() = %s; want nil", err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "gpfs/proj/latest"))
+	if err != nil || info.Size() != 5000 || !info.ModTime().Equal(fx.Mtime) {
+		t.Fatalf("os.Stat(latest) = %v, %v; want data.h5 of 5000 bytes", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "gpfs/proj/sub")); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("os.Stat(sub) = %v, %v; want directory with mode 0700", info, err)
+	}
+	if have, err := b.State(filepath.Join(root, "gpfs/proj/data.h5")); err != nil || have != storage.Migrated {
+		t.Fatalf("fake.Backend.State(data.h5) = %s, %v; want %s", have, err, storage.Migrated)
+	}
+	// Only the created tree is answered for
+	if _, err := b.State("/gpfs/proj/data.h5"); err == nil {
+		t.Fatalf("fake.Backend.State(/gpfs/proj/data.h5) = nil; want error")
+	}
+}
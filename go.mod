@@ -1,10 +1,12 @@
-module gls
+module github.com/olcf/gls
 
 go 1.19
 
 require (
+	github.com/mattn/go-isatty v0.0.14
 	github.com/rs/zerolog v1.28.0
 	github.com/spf13/afero v1.9.2
+	golang.org/x/text v0.3.4
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
 
@@ -12,8 +14,6 @@ require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20211218093645-b94a6e3cc137 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
-	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/stretchr/testify v1.8.0 // indirect
 	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
-	golang.org/x/text v0.3.4 // indirect
 )
@@ -0,0 +1,52 @@
+package ls
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// Read the listing from fs instead of the operating system's filesystem, e.g. an afero.MemMapFs in tests
+func (l *List) SetFs(fs afero.Fs) {
+	l.fs = fs
+}
+
+// The filesystem set with SetFs, or the operating system's
+func (l *List) filesystem() afero.Fs {
+	if l.fs == nil {
+		return afero.NewOsFs()
+	}
+	return l.fs
+}
+
+// The stat data of info. Filesystems other than the OS' (see SetFs) have none, so it is made up from what info has:
+// owned by whoever lists it, fully allocated and last accessed and changed when it was modified
+func sysStat(info os.FileInfo) *syscall.Stat_t {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st
+	}
+	mtime := syscall.NsecToTimespec(info.ModTime().UnixNano())
+	return &syscall.Stat_t{
+		Mode:    uint32(info.Mode().Perm()),
+		Nlink:   1,
+		Uid:     uint32(os.Getuid()),
+		Gid:     uint32(os.Getgid()),
+		Size:    info.Size(),
+		Blksize: 4096,
+		Blocks:  (info.Size() + 511) / 512,
+		Atim:    mtime,
+		Mtim:    mtime,
+		Ctim:    mtime,
+	}
+}
+
+// A FileInfo under a different name. Filesystems other than the OS' name "dir/." after dir
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (f renamedFileInfo) Name() string {
+	return f.name
+}
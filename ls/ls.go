@@ -1,30 +1,36 @@
 package ls
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"gls/columnize"
+	"github.com/olcf/gls/columnize"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"text/template"
 	"time"
 
-	"gls/config"
+	"github.com/olcf/gls/cache"
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/policy"
+	"github.com/olcf/gls/stats"
+	"github.com/olcf/gls/storage"
+	"github.com/olcf/gls/throttle"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/afero"
 )
 
-// #cgo LDFLAGS: -L ../attr_check/lib -lgpfs -lstdc++ -lattr_check
-// #cgo CFLAGS: -I ../attr_check
-// #include "attr_check.h"
-import "C"
-
 type XAttr int
 
 const (
@@ -33,6 +39,34 @@ const (
 	Ret2
 )
 
+// Output formats, see Flags.Format
+const (
+	FormatText = "text"
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+)
+
+// How the storage state is shown next to file names, see Flags.Indicator
+const (
+	IndicatorNone = "none"
+	IndicatorText = "text"
+	// A single character per state in its own column in front of the name
+	IndicatorSymbol = "symbol"
+	// The same character appended to the name, like ls -F
+	IndicatorSuffix = "suffix"
+)
+
+// Aggregate storage state of the files below a directory. Only computed with --dir-state
+type DirState int
+
+const (
+	DirUnknown DirState = iota
+	DirEmpty
+	DirResident
+	DirPremigrated
+	DirMigrated
+	DirMixed
+)
 
 // Wrapper around os.FileInfo. Including the FileInfo struct as well. Prbably need to collapse this into 1 object
 type fileInfoAttr struct {
@@ -43,6 +77,18 @@ type fileInfoAttr struct {
 	State     XAttr
 	Size      int64
 	Mode      string
+	// Only populated with --pools
+	Pool       string
+	TapeCopies []TapeCopy
+	// Only populated for directories with --dir-state. DirPartial is set when the depth or time budget ran out
+	DirState   DirState
+	DirPartial bool
+	// Only populated for symbolic links. State holds the state of the target since attr_check follows the link
+	Target     string
+	TargetMode os.FileMode
+	Broken     bool
+	// The storage state came from Flags.Cache without being looked up
+	Cached bool
 }
 
 // Flags to modify the way the output is printed to the screen.
@@ -54,7 +100,36 @@ type Flags struct {
 	SortByTime bool
 	Color      map[string]bool
 	NoColor    bool
-	Debug      bool
+	// One of the Indicator* constants. Defaults to text with NoColor (the original -n behavior), otherwise none
+	Indicator   string
+	Debug       bool
+	Pools       bool
+	DirState    bool
+	Directory   bool
+	Dereference bool
+	// Colors for storage states; columnize.DefaultTheme when nil
+	Theme *columnize.Theme
+	// Parsed LS_COLORS; nil when unset
+	LSColors *columnize.LSColors
+	// Replaces the normal and long listing formats when set. Executed against an Entry per file, see NewTemplate
+	Template *template.Template
+	// One of the Format* constants. Empty means FormatText
+	Format string
+	// Columns written by FormatCSV and FormatTSV, see Columns
+	Columns []string
+	// Paths whose storage state changed since the previous refresh in watch mode. They are shown highlighted,
+	// and with ChangedOnly nothing else is printed
+	Changed     map[string]bool
+	ChangedOnly bool
+	// Explain why resident files haven't been migrated according to Policy and, when it is set, which ILM rule
+	// will migrate them at the next policy run
+	Why    bool
+	Policy *policy.Rules
+	ILM    *policy.ILMPolicy
+	// Storage states from earlier listings, see --cache. nil looks every file up
+	Cache *cache.Cache
+	// Records where the time goes for --stats; nil records nothing
+	Stats *stats.Recorder
 }
 
 func (l *List) SetFlags(f Flags) {
@@ -70,9 +145,119 @@ func (l *List) SetFlags(f Flags) {
 type List struct {
 	paths     []string
 	fileInfos map[string][]fileInfoAttr
+	// Shared by every directory when computing --dir-state so the whole listing stays within config.DirStateTimeBudget
+	dirStateDeadline time.Time
+	// See SetFs
+	fs afero.Fs
+	// Bounds the files looked up at once across every directory, see StatAll
+	limiter *throttle.Limiter
 	Flags
 }
 
+// The indicator in use. See Flags.Indicator
+func (l *List) indicator() string {
+	if len(l.Flags.Indicator) == 0 {
+		if l.Flags.NoColor {
+			return IndicatorText
+		}
+		return IndicatorNone
+	}
+	return l.Flags.Indicator
+}
+
+// Add the storage state to a file name using the configured indicator: "(label) name" for text, the name followed by
+// Suffix(symbol) for suffix. With IndicatorSymbol the name is left alone since the symbol gets its own column (see
+// getSymbolColumn)
+func (l *List) annotate(name string, label string, symbol string) string {
+	switch l.indicator() {
+	case IndicatorText:
+		if len(label) > 0 {
+			return fmt.Sprintf("(%s) %s", label, name)
+		}
+	case IndicatorSuffix:
+		return name + Suffix(symbol)
+	}
+	return name
+}
+
+// What --indicator=suffix appends for a config *Symbol, i.e. the matching config *Suffix
+func Suffix(symbol string) string {
+	suffixes := map[string]string{
+		config.Ret0Symbol:           config.Ret0Suffix,
+		config.Ret1Symbol:           config.Ret1Suffix,
+		config.Ret2Symbol:           config.Ret2Suffix,
+		config.TooLargeSymbol:       config.TooLargeSuffix,
+		config.BrokenLinkSymbol:     config.BrokenLinkSuffix,
+		config.DirResidentSymbol:    config.DirResidentSuffix,
+		config.DirPremigratedSymbol: config.DirPremigratedSuffix,
+		config.DirMigratedSymbol:    config.DirMigratedSuffix,
+		config.DirMixedSymbol:       config.DirMixedSuffix,
+	}
+	return suffixes[symbol]
+}
+
+// The symbol column for IndicatorSymbol. Entries without a state get a blank so the names stay aligned
+func (l *List) getSymbolColumn(file fileInfoAttr) string {
+	if _, symbol := l.getStateLabel(file); len(symbol) > 0 {
+		return symbol
+	}
+	return " "
+}
+
+// Text and symbol describing the storage state of a file, or empty strings if there is nothing to show
+func (l *List) getStateLabel(file fileInfoAttr) (label string, symbol string) {
+	switch {
+	case file.FileInfo.IsDir():
+		if !l.Flags.DirState {
+			return "", ""
+		}
+		switch file.DirState {
+		case DirResident:
+			label, symbol = config.DirResidentStr, config.DirResidentSymbol
+		case DirPremigrated:
+			label, symbol = config.DirPremigratedStr, config.DirPremigratedSymbol
+		case DirMigrated:
+			label, symbol = config.DirMigratedStr, config.DirMigratedSymbol
+		case DirMixed:
+			label, symbol = config.DirMixedStr, config.DirMixedSymbol
+		case DirEmpty:
+			label = config.DirEmptyStr
+		case DirUnknown:
+			if file.DirPartial {
+				return config.DirNotCheckedStr, ""
+			}
+		}
+		if file.DirPartial && len(label) > 0 {
+			label += ", partial"
+		}
+		return label, symbol
+	case isSymlink(file.FileInfo) && file.Broken:
+		return config.BrokenLinkStr, config.BrokenLinkSymbol
+	case isSymlink(file.FileInfo):
+		// The state of the target is shown after the arrow in text mode
+		return "", stateSymbol(file.State)
+	case bytesToGB(file.FileInfo.Size()) > config.MaxFileSizeGB && config.DisableSizeChecking != true:
+		return config.TooLargeStr, config.TooLargeSymbol
+	}
+	return stateString(file.State), stateSymbol(file.State)
+}
+
+// Drop the color when coloring is disabled so no escape sequences end up in the output
+func (l *List) color(c columnize.Color) columnize.Color {
+	if l.Flags.NoColor {
+		return ""
+	}
+	return c
+}
+
+// The theme in use, falling back to the default colors
+func (l *List) theme() *columnize.Theme {
+	if l.Flags.Theme == nil {
+		return &columnize.DefaultTheme
+	}
+	return l.Flags.Theme
+}
+
 // boilerplate
 func checkErr(err error) {
 	if err != nil {
@@ -85,12 +270,11 @@ func bytesToGB(size int64) int64 {
 	return ((size / 1024) / 1024) / 1024
 }
 
-// Worker thread that stats a file and gets the right data for it.
+// Worker thread that stats a file and gets the right data for it. The limiter decides when it may look the file up
 func (l *List) fileStatWorker(input chan string, output chan fileInfoAttr, wg *sync.WaitGroup, base string) {
 	defer wg.Done()
 	for cur := range input {
-		fia := l.doFileStat(cur, base)
-		output <- fia
+		output <- l.doFileStat(cur, base)
 	}
 }
 
@@ -98,22 +282,19 @@ func (l *List) fileStatWorker(input chan string, output chan fileInfoAttr, wg *s
 // files: Slice of files in the directory
 // base: The base dir path
 func (l *List) doBulkFileStat(files []string, base string) []fileInfoAttr {
-	maxNProcs := config.MaxGoRoutines
-	var nProcs int
-	if config.AlwaysUseMaxGoRoutines {
-		nProcs = maxNProcs
-	} else {
-		if len(files) < maxNProcs {
-			// Limit the number of goroutines created. No need for 16 or 32 threads, for only 5 files
-			nProcs = len(files)/2 + 1
-		} else {
-			// If there are more files than max number of allowable threads, then use the max thread num
-			nProcs = maxNProcs
-		}
+	// Workers are cheap while waiting on the limiter, which is shared by every directory in the run and decides how
+	// many files are really looked up at once
+	nProcs := config.MaxGoRoutines
+	if len(files) < nProcs {
+		nProcs = len(files)
+	}
+	if nProcs < 1 {
+		nProcs = 1
 	}
+	l.Flags.Stats.Workers(nProcs)
 	if l.Flags.Debug {
 		log.Debug().Msgf("Launching %s threads", strconv.Itoa(nProcs))
-		log.Debug().Msgf("Maximum threads: %s", strconv.Itoa(maxNProcs))
+		log.Debug().Msgf("Current limit: %s of at most %s", strconv.Itoa(l.limiter.Limit()), strconv.Itoa(config.MaxGoRoutines))
 		log.Debug().Msgf("Cores: %s", strconv.Itoa(runtime.NumCPU()))
 	}
 	inputChan := make(chan string, len(files))
@@ -145,40 +326,131 @@ func (l *List) doBulkFileStat(files []string, base string) []fileInfoAttr {
 
 // Performs the file stat and checks extended GPFS attributes
 func (l *List) doFileStat(file string, base string) fileInfoAttr {
-	fInfo, err := os.Lstat(file)
+	fs := l.filesystem()
+	var fInfo os.FileInfo
+	var err error
+	l.limiter.Do(func() {
+		start := time.Now()
+		if l.Flags.Dereference {
+			fInfo, err = fs.Stat(file)
+		}
+		// Without -L, or when the link is broken and there's nothing to dereference, show the link itself
+		if !l.Flags.Dereference || err != nil {
+			fInfo, err = storage.Lstat(fs, file)
+		}
+		l.Flags.Stats.Done(stats.Lstat, start, err != nil)
+	})
 	checkErr(err)
+	if name := filepath.Base(file); (name == "." || name == "..") && fInfo.Name() != name {
+		fInfo = renamedFileInfo{fInfo, name}
+	}
 	fia := fileInfoAttr{
 		FileInfo: fInfo,
 		State:    -1,
 	}
+	start := time.Now()
 	fia.populateMetadata()
-	if !fia.FileInfo.IsDir() && l.Flags.Color[base] {
-		fileStatus := attr_check(file)
-		switch fileStatus {
-		case 0:
-			fia.State = Ret0
-		case 1:
-			fia.State = Ret1
-		case 2:
-			fia.State = Ret2
+	l.Flags.Stats.Since(stats.Owner, start)
+	targetIsDir := false
+	// The file attr_check looks at, i.e. the target of a link
+	stateInfo := fInfo
+	if isSymlink(fInfo) {
+		target, targetInfo, err := storage.ResolveLink(fs, file)
+		fia.Target = target
+		if err == nil {
+			targetIsDir = targetInfo.IsDir()
+			fia.TargetMode = targetInfo.Mode()
+			stateInfo = targetInfo
+		} else {
+			log.Debug().Msgf("Unable to resolve symlink %s: %s", file, err)
+			fia.Broken = true
+		}
+	}
+	if !fia.FileInfo.IsDir() && !fia.Broken && !targetIsDir && l.Flags.Color[base] {
+		l.lookupState(&fia, file, stateInfo)
+	}
+	l.Flags.Stats.Entry(fia.Cached)
+	// The directories passed on the command line only need a state of their own with -d
+	if fia.FileInfo.IsDir() && l.Flags.DirState && l.Flags.Color[base] && (file != base || l.Flags.Directory) {
+		if name := fia.FileInfo.Name(); name != "." && name != ".." {
+			fia.DirState, fia.DirPartial = l.dirStateOf(file)
 		}
 	}
 
 	return fia
 }
 
-// Make pretty size values
-func humanizeSize(b int64) string {
-	const unit = 1000
-	if b < unit {
-	return fmt.Sprintf("%d B", b)
+// Fill in the storage state, and with --pools the pool and tape copies, of file from Flags.Cache if it has an
+// unchanged record for it, otherwise with attr_check. info is the file attr_check looks at
+func (l *List) lookupState(fia *fileInfoAttr, file string, info os.FileInfo) {
+	key, keyed := cache.KeyOf(info)
+	pools := l.lookupPools()
+	if r, ok := l.Flags.Cache.Get(file, key, pools); keyed && ok {
+		fia.State, fia.Cached = XAttr(r.State), true
+		if pools {
+			fia.Pool = r.Pool
+			fia.TapeCopies = storage.ParseTapeCopies(r.Attributes)
+		}
+		return
 	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	fileStatus := l.attrCheck(file)
+	switch fileStatus {
+	case 0:
+		fia.State = Ret0
+	case 1:
+		fia.State = Ret1
+	case 2:
+		fia.State = Ret2
 	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+	r := cache.Record{Key: key, State: storage.State(fia.State), Pools: pools}
+	if pools {
+		fia.Pool = l.storagePool(file)
+		if fia.State == Ret1 || fia.State == Ret2 {
+			r.Attributes = l.attrDump(file)
+			fia.TapeCopies = storage.ParseTapeCopies(r.Attributes)
+		}
+		r.Pool = fia.Pool
+	}
+	// Failed lookups are tried again next time
+	if keyed && fileStatus >= 0 {
+		l.Flags.Cache.Put(file, r)
+	}
+}
+
+// attr_check within the run's limiter
+func (l *List) attrCheck(path string) int {
+	status := -1
+	l.limiter.Do(func() {
+		start := time.Now()
+		status = attr_check(path)
+		l.Flags.Stats.Done(stats.Attr, start, status < 0)
+	})
+	return status
+}
+
+// storage_pool within the run's limiter
+func (l *List) storagePool(path string) string {
+	var pool string
+	l.limiter.Do(func() {
+		defer l.Flags.Stats.Since(stats.Pool, time.Now())
+		pool = storage_pool(path)
+	})
+	return pool
+}
+
+// attr_dump within the run's limiter
+func (l *List) attrDump(path string) string {
+	var attrs string
+	l.limiter.Do(func() {
+		defer l.Flags.Stats.Since(stats.Dump, time.Now())
+		attrs = attr_dump(path)
+	})
+	return attrs
+}
+
+// Whether to look up the pool and tape copies of files: with --pools, and with --why when ILM rules may check FROM POOL
+func (l *List) lookupPools() bool {
+	return l.Flags.Pools || (l.Flags.Why && l.Flags.ILM != nil)
 }
 
 // Since the stdlib function doesn't take into account extra data like directories, symlinks, stickybits, etc, lets make our own
@@ -235,11 +507,11 @@ func New(inputPaths []string) *List {
 
 // Look up the username, and group name so we're not just looking at integers here; Make the mTime look pretty, as well as the mode string
 func (f *fileInfoAttr) populateMetadata() {
-	uid := f.FileInfo.Sys().(*syscall.Stat_t).Uid
-	gid := f.FileInfo.Sys().(*syscall.Stat_t).Gid
-	username, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	// Filesystems other than the OS' (see List.SetFs) have no owners; their files belong to whoever lists them
+	st := sysStat(f.FileInfo)
+	username, err := user.LookupId(strconv.FormatUint(uint64(st.Uid), 10))
 	checkErr(err)
-	group, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10))
+	group, err := user.LookupGroupId(strconv.FormatUint(uint64(st.Gid), 10))
 	checkErr(err)
 
 	f.Mode = fileModeToString(f.FileInfo.Mode())
@@ -267,34 +539,80 @@ func (l *List) getLongListing(fileInfo fileInfoAttr) string {
 	curLine += fileInfo.Groupname + "\t"
 	// Get file size and make human readable if -h
 	if l.Flags.Human {
-		curLine += humanizeSize(fileInfo.Size) + "\t"
+		curLine += columnize.HumanizeSize(fileInfo.Size) + "\t"
 		//Make file.FileInfo.Size() human readable
 	} else {
 		curLine += strconv.FormatInt(fileInfo.Size, 10) + "\t"
 	}
 	// Find mtime and make human readable
-	curLine += fileInfo.Mtime + "\t "
+	curLine += fileInfo.Mtime
+	if l.Flags.Pools {
+		curLine += "\t" + fileInfo.getPoolColumns()
+	}
 	return curLine
 }
 
+// A single tape copy of a migrated/premigrated file as recorded by Spectrum Archive
+type TapeCopy = storage.TapeCopy
+
+// Does this file have fewer tape copies than the site requires? Only files that have been (pre)migrated can be short on copies
+func (f *fileInfoAttr) missingTapeCopies() bool {
+	if f.State != Ret1 && f.State != Ret2 {
+		return false
+	}
+	return len(f.TapeCopies) < config.MinTapeCopies
+}
+
+// Pool and tape copy columns used by --pools in long listings
+func (f *fileInfoAttr) getPoolColumns() string {
+	pool := f.Pool
+	if len(pool) == 0 {
+		pool = "-"
+	}
+	copies := "-"
+	if len(f.TapeCopies) > 0 {
+		var tapes []string
+		for _, c := range f.TapeCopies {
+			tapes = append(tapes, c.Volser+"@"+c.Pool)
+		}
+		copies = strings.Join(tapes, ",")
+	}
+	if f.missingTapeCopies() {
+		copies += fmt.Sprintf(" (%d/%d copies)", len(f.TapeCopies), config.MinTapeCopies)
+	}
+	return pool + "\t" + copies
+}
+
 // Launch batches of workers for all directories passed into List
 func (l *List) StatAll() {
 	// Stat everything in paths and populate l.fileInfos
 	l.fileInfos = make(map[string][]fileInfoAttr)
+	l.dirStateDeadline = time.Now().Add(config.DirStateTimeBudget)
+	// Kept across refreshes in watch mode so the limit it learned carries over
+	if l.limiter == nil {
+		l.limiter = throttle.Default()
+	}
+	// Answers prefetched by an earlier refresh are out of date
+	if p, ok := storage.DefaultBackend.(storage.Prefetcher); ok {
+		p.Prefetch(nil, false)
+	}
 
 	for _, path := range l.paths {
 		baseDirSlice := strings.Split(path, "/")
 		baseDir := strings.Join(baseDirSlice[:len(baseDirSlice)-1], "/")
 		fia := l.doFileStat(path, path)
-		if !fia.FileInfo.IsDir() {
+		// With -d directories are listed like any other file instead of showing their contents
+		if !fia.FileInfo.IsDir() || l.Flags.Directory {
 			l.fileInfos[baseDir] = append(l.fileInfos[baseDir], fia)
 		} else {
-			// use regex with filepath.Glob to get just visable files or all files if -a
+			// use regex with afero.Glob to get just visable files or all files if -a
 			rex := `/[^\.]*`
 			if l.Flags.All {
 				rex = `/*`
 			}
-			files, err := filepath.Glob(path + rex)
+			start := time.Now()
+			files, err := afero.Glob(l.filesystem(), path+rex)
+			l.Flags.Stats.Done(stats.DirRead, start, err != nil)
 			checkErr(err)
 			var dirEntries []fileInfoAttr
 			if l.Flags.All {
@@ -303,6 +621,9 @@ func (l *List) StatAll() {
 				dots := []fileInfoAttr{curDir, parentDir}
 				dirEntries = append(dots, dirEntries...)
 			}
+			if p, ok := storage.DefaultBackend.(storage.Prefetcher); ok && l.Flags.Color[path] && len(files) > 0 {
+				p.Prefetch(files, l.lookupPools())
+			}
 			if len(files) > 0 {
 				l.fileInfos[path] = make([]fileInfoAttr, len(files))
 				dirEntries = append(dirEntries, l.doBulkFileStat(files, path)...)
@@ -311,70 +632,270 @@ func (l *List) StatAll() {
 
 		}
 	}
+	l.Flags.Stats.Limiter(l.limiter.Peak(), l.limiter.Limit())
+}
+
+// Tally the storage state of every regular file up to config.DirStateMaxDepth levels below dir.
+// Returns true if the result is partial, i.e. the deadline passed, a directory couldn't be read or deeper directories were skipped
+func (l *List) walkDirState(dir string, depth int, counts map[XAttr]int) bool {
+	entries, err := afero.ReadDir(l.filesystem(), dir)
+	if err != nil {
+		log.Debug().Msgf("Unable to read %s for directory state: %s", dir, err)
+		return true
+	}
+	partial := false
+	for _, entry := range entries {
+		if time.Now().After(l.dirStateDeadline) {
+			return true
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if depth >= config.DirStateMaxDepth {
+				partial = true
+			} else if l.walkDirState(path, depth+1, counts) {
+				partial = true
+			}
+		} else if entry.Mode().IsRegular() {
+			counts[XAttr(l.attrCheck(path))]++
+		}
+	}
+	return partial
+}
+
+// Work out the aggregate state of the files below dir by l.dirStateDeadline
+func (l *List) dirStateOf(dir string) (DirState, bool) {
+	counts := make(map[XAttr]int)
+	partial := l.walkDirState(dir, 1, counts)
+	log.Debug().Msgf("Directory state for %s: %v (partial: %t)", dir, counts, partial)
+	switch {
+	case len(counts) == 0 && partial:
+		return DirUnknown, partial
+	case len(counts) == 0:
+		return DirEmpty, partial
+	case len(counts) > 1:
+		return DirMixed, partial
+	case counts[Ret0] > 0:
+		return DirResident, partial
+	case counts[Ret1] > 0:
+		return DirPremigrated, partial
+	case counts[Ret2] > 0:
+		return DirMigrated, partial
+	}
+	return DirUnknown, partial
+}
+
+// Name and color of a directory based upon the state of its contents (--dir-state)
+func (l *List) getDirStateName(file fileInfoAttr) (string, columnize.Color) {
+	var color columnize.Color
+	theme := l.theme()
+	switch file.DirState {
+	case DirResident:
+		color = theme.DirResident
+	case DirPremigrated:
+		color = theme.DirPremigrated
+	case DirMigrated:
+		color = theme.DirMigrated
+	case DirMixed:
+		color = theme.DirMixed
+	default:
+		color = theme.Directory
+	}
+	name := file.FileInfo.Name()
+	// Text mode says "partial" in the label instead
+	if file.DirPartial && l.indicator() != IndicatorText && (!l.Flags.NoColor || l.indicator() != IndicatorNone) {
+		name += "?"
+	}
+	label, symbol := l.getStateLabel(file)
+	return l.annotate(name, label, symbol), l.color(color)
 }
 
-// Get modified filename to show where the symlink points
-func (l *List) getSymlinkString(f os.FileInfo, base string) string {
-	target, err := filepath.EvalSymlinks(base + "/" + f.Name())
+// Get modified filename to show where the symlink points, along with the storage state of the target.
+// Broken links are shown in the orphan color (or annotated with --no-color)
+func (l *List) getSymlinkString(f fileInfoAttr, base string) string {
+	target := f.Target
 	if base != "/" {
 		target = strings.Replace(target, base, ".", 1)
 	}
-	checkErr(err)
-	var curLine string
-	if l.Flags.NoColor {
-		curLine = columnize.Colorize(columnize.Reset, f.Name())
-	} else {
-		curLine = columnize.Colorize(columnize.LightBlue, f.Name())
-	}
+	label, symbol := l.getStateLabel(f)
+	curLine := columnize.Colorize(l.color(l.getTypeColor(f)), l.annotate(f.FileInfo.Name(), label, symbol))
 	if l.Flags.Long {
-		curLine += " -> " + target
+		curLine += " -> " + l.getSymlinkTarget(f, target)
 	}
 	return curLine
 }
 
+// Color (or annotate) the symlink target by its storage state
+func (l *List) getSymlinkTarget(f fileInfoAttr, target string) string {
+	if f.Broken {
+		return columnize.Colorize(l.color(l.getTypeColor(f)), target)
+	}
+	if state := stateString(f.State); len(state) > 0 && l.indicator() == IndicatorText {
+		target = fmt.Sprintf("%s (%s)", target, state)
+	}
+	if color, ok := l.getStateColor(f.State); ok {
+		return columnize.Colorize(l.color(color), target)
+	}
+	return target
+}
+
+// Foreground and background colors for a storage state
+func (l *List) getStateColors(state XAttr) (fg columnize.Color, bg columnize.Color, ok bool) {
+	theme := l.theme()
+	switch state {
+	case Ret0:
+		return theme.Resident, theme.ResidentBg, true
+	case Ret1:
+		return theme.Premigrated, theme.PremigratedBg, true
+	case Ret2:
+		return theme.Migrated, theme.MigratedBg, true
+	}
+	return "", "", false
+}
+
+func (l *List) getStateColor(state XAttr) (columnize.Color, bool) {
+	fg, _, ok := l.getStateColors(state)
+	return fg, ok
+}
+
+// Color for a file based upon its type alone: LS_COLORS if it has an entry, otherwise the theme
+func (l *List) getTypeColor(file fileInfoAttr) columnize.Color {
+	color, _ := l.getLSColor(file)
+	return color
+}
+
+// Same as getTypeColor. specific is false when nothing more than a default file color applies
+func (l *List) getLSColor(file fileInfoAttr) (color columnize.Color, specific bool) {
+	name, mode := file.FileInfo.Name(), file.FileInfo.Mode()
+	// With ln=target a link is colored like the file it points to, suffix included
+	if l.Flags.LSColors.LinkTarget() && isSymlink(file.FileInfo) && !file.Broken {
+		name, mode = filepath.Base(file.Target), file.TargetMode
+	}
+	if l.Flags.LSColors != nil {
+		if color, specific = l.Flags.LSColors.ColorFor(name, mode, file.Broken); len(color) > 0 {
+			return color, specific
+		}
+	}
+	theme := l.theme()
+	switch {
+	case mode.IsDir():
+		return theme.Directory, true
+	case mode&os.ModeSymlink != 0 && file.Broken:
+		return theme.Orphan, true
+	case mode&os.ModeSymlink != 0:
+		return theme.Symlink, true
+	}
+	return columnize.Reset, false
+}
+
+// Combine the color LS_COLORS gives a file with its storage state according to the theme's precedence
+func (l *List) getFileColor(file fileInfoAttr) columnize.Color {
+	lsColor, specific := l.getLSColor(file)
+	fg, bg, ok := l.getStateColors(file.State)
+	if !ok {
+		return lsColor
+	}
+	switch l.theme().Precedence {
+	case columnize.PrecedenceBackground:
+		return lsColor + bg
+	case columnize.PrecedenceLSColors:
+		if specific {
+			return lsColor
+		}
+	}
+	return fg
+}
+
 // Make pretty colors based upon attributes like symlink, storage pool, etc
 func (l *List) getProcessedFilename(file fileInfoAttr, base string) (string, columnize.Color) {
 	if file.FileInfo.IsDir() {
-		var color columnize.Color = columnize.Blue
-		if l.Flags.NoColor {
-			color = columnize.Reset
+		if l.Flags.DirState && (file.DirState != DirUnknown || file.DirPartial) {
+			return l.getDirStateName(file)
 		}
-		return file.FileInfo.Name(), color
+		return file.FileInfo.Name(), l.color(l.getTypeColor(file))
 	} else if isSymlink(file.FileInfo) {
-		var color columnize.Color = columnize.LightBlue
-		if l.Flags.NoColor {
-			color = columnize.Reset
-		}
-		return l.getSymlinkString(file.FileInfo, base), color
-	} else if bytesToGB(file.FileInfo.Size()) > config.MaxFileSizeGB && config.DisableSizeChecking != true {
-		if l.Flags.NoColor {
-			return fmt.Sprintf("%s %s", "(TOO LARGE TO MIGRATE)", file.FileInfo.Name()), columnize.Reset
-		} else {
-			return file.FileInfo.Name(), columnize.BlinkingRedBackground
-		}
+		return l.getSymlinkString(file, base), l.color(l.getTypeColor(file))
 	}
-	switch file.State {
-	case 0:
-		if l.Flags.NoColor {
-			return fmt.Sprintf("(%s) %s", config.Ret0Str, file.FileInfo.Name()), columnize.Reset
-		} else {
-			return file.FileInfo.Name(), columnize.Green
-		}
-	case 1:
+	label, symbol := l.getStateLabel(file)
+	name := l.annotate(file.FileInfo.Name(), label, symbol)
+	if bytesToGB(file.FileInfo.Size()) > config.MaxFileSizeGB && config.DisableSizeChecking != true {
+		return name, l.color(l.theme().TooLarge)
+	}
+	return name, l.color(l.getFileColor(file))
+}
+
+// getProcessedFilename plus the mark on cached states and the highlighting of entries that changed state in watch
+// mode and of entries about to be migrated
+func (l *List) getDisplayName(file fileInfoAttr, base string) (string, columnize.Color) {
+	name, color := l.getProcessedFilename(file, base)
+	// Cached states may be out of date, so they never pass for a fresh lookup
+	if file.Cached {
+		name += " (" + config.CachedStr + ")"
+	}
+	// Files an ILM rule will migrate at the next policy run are underlined
+	if v, ok := l.getVerdict(file, base); ok && v.Eligible && len(v.Rule) != 0 && !l.Flags.NoColor {
+		color = columnize.Underline + color
+	}
+	if l.Flags.Changed[entryPath(file, base)] {
 		if l.Flags.NoColor {
-			return fmt.Sprintf("(%s) %s", config.Ret1Str, file.FileInfo.Name()), columnize.Reset
-		} else {
-			return file.FileInfo.Name(), columnize.Yellow
+			return name + " (" + config.ChangedStr + ")", color
 		}
-	case 2:
-		if l.Flags.NoColor {
-			return fmt.Sprintf("(%s) %s", config.Ret2Str, file.FileInfo.Name()), columnize.Reset
-		} else {
-			return file.FileInfo.Name(), columnize.Red
+		return name, columnize.Reverse + color
+	}
+	return name, color
+}
+
+// With --why, the reason a resident file is still on disk according to Flags.Policy, or what Flags.ILM
+// will do with it if the simple rules don't hold it back. ok is false for everything but resident files
+func (l *List) getVerdict(file fileInfoAttr, base string) (v policy.Verdict, ok bool) {
+	if !l.Flags.Why || l.Flags.Policy == nil || file.State != Ret0 || !file.FileInfo.Mode().IsRegular() {
+		return v, false
+	}
+	f := policyFile(file, entryPath(file, base))
+	v = l.Flags.Policy.Check(f, time.Now())
+	if v.Eligible && l.Flags.ILM != nil {
+		var err error
+		if v, err = l.Flags.ILM.Check(f, time.Now()); err != nil {
+			v.Reason = "policy error: " + err.Error()
 		}
-	default:
-		return file.FileInfo.Name(), columnize.Reset
 	}
+	return v, true
+}
+
+// The --why text for a file. Empty when there is nothing to explain
+func (l *List) getWhy(file fileInfoAttr, base string) string {
+	v, _ := l.getVerdict(file, base)
+	return v.Reason
+}
+
+// The stat data the policy rules look at
+func policyFile(file fileInfoAttr, path string) policy.File {
+	st := sysStat(file.FileInfo)
+	return policy.File{
+		Path:        path,
+		Size:        file.Size,
+		KBAllocated: st.Blocks / 2,
+		Atime:       time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)),
+		Mtime:       file.FileInfo.ModTime(),
+		Ctime:       time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)),
+		UID:         st.Uid,
+		GID:         st.Gid,
+		Pool:        file.Pool,
+	}
+}
+
+// Full path of a listed file
+func entryPath(file fileInfoAttr, base string) string {
+	return filepath.Join(base, file.FileInfo.Name())
+}
+
+// The directories in the listing in the order they are printed
+func (l *List) bases() []string {
+	var bases []string
+	for base := range l.fileInfos {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	return bases
 }
 
 // Sort the output based upon values in List.Flags
@@ -401,53 +922,807 @@ func (l *List) Sort() {
 	log.Debug().Msgf("Sort finished")
 }
 
-// Print the whole list to the screen. This includes all paths in List
-func (l *List) Print() {
+// A single listed file as seen by --format-template
+type Entry struct {
+	// Base name and full path of the file
+	Name string
+	Path string
+	Type string
+	// The name as it appears in a normal listing: colored, annotated and followed by the target of a symbolic link
+	Display string
+	Size    int64
+	Mode    string
+	Owner   string
+	Group   string
+	Mtime   time.Time
+	// Storage state label (e.g. config.Ret2Str) and symbol. For directories this is the --dir-state label
+	State  string
+	Symbol string
+	// Only populated with --pools
+	Pool    string
+	TapeIDs []string
+	// Target of a symbolic link and whether it is missing
+	Target   string
+	Broken   bool
+	TooLarge bool
+	// With --why, why a resident file hasn't been migrated
+	Why string
+	// The state came from the cache without being looked up (see --cache)
+	Cached bool
+}
+
+// Functions available to --format-template in addition to the text/template builtins
+var templateFuncs = template.FuncMap{
+	"human": columnize.HumanizeSize,
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Parse a --format-template. Tabs in the template separate columns that are aligned across the listing
+func NewTemplate(text string) (*template.Template, error) {
+	return template.New("format").Funcs(templateFuncs).Parse(text)
+}
+
+func (l *List) newEntry(file fileInfoAttr, base string) Entry {
+	name, color := l.getDisplayName(file, base)
+	e := Entry{
+		Name:     file.FileInfo.Name(),
+		Path:     entryPath(file, base),
+		Type:     fileTypeString(file.FileInfo.Mode()),
+		Display:  columnize.Colorize(color, name),
+		Size:     file.Size,
+		Mode:     file.Mode,
+		Owner:    file.Username,
+		Group:    file.Groupname,
+		Mtime:    file.FileInfo.ModTime(),
+		State:    stateString(file.State),
+		Symbol:   stateSymbol(file.State),
+		Pool:     file.Pool,
+		Target:   file.Target,
+		Broken:   file.Broken,
+		Cached:   file.Cached,
+		TooLarge: !file.FileInfo.IsDir() && !isSymlink(file.FileInfo) && bytesToGB(file.Size) > config.MaxFileSizeGB && config.DisableSizeChecking != true,
+	}
+	if file.FileInfo.IsDir() {
+		e.State, e.Symbol = l.getStateLabel(file)
+	}
+	e.Why = l.getWhy(file, base)
+	for _, c := range file.TapeCopies {
+		e.TapeIDs = append(e.TapeIDs, c.Volser)
+	}
+	return e
+}
+
+// Columns available to --format=csv and --format=tsv. Sizes are in bytes and times are RFC 3339
+var columns = map[string]func(e Entry) string{
+	"name":      func(e Entry) string { return e.Name },
+	"path":      func(e Entry) string { return e.Path },
+	"type":      func(e Entry) string { return e.Type },
+	"size":      func(e Entry) string { return strconv.FormatInt(e.Size, 10) },
+	"mode":      func(e Entry) string { return e.Mode },
+	"owner":     func(e Entry) string { return e.Owner },
+	"group":     func(e Entry) string { return e.Group },
+	"mtime":     func(e Entry) string { return e.Mtime.Format(time.RFC3339) },
+	"state":     func(e Entry) string { return e.State },
+	"pool":      func(e Entry) string { return e.Pool },
+	"tapes":     func(e Entry) string { return strings.Join(e.TapeIDs, ",") },
+	"target":    func(e Entry) string { return e.Target },
+	"broken":    func(e Entry) string { return strconv.FormatBool(e.Broken) },
+	"too_large": func(e Entry) string { return strconv.FormatBool(e.TooLarge) },
+	"why":       func(e Entry) string { return e.Why },
+	"cached":    func(e Entry) string { return strconv.FormatBool(e.Cached) },
+}
+
+// Names of the columns available to --format=csv and --format=tsv
+func Columns() []string {
+	var names []string
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check that every name in cols is a known column
+func CheckColumns(cols []string) error {
+	for _, c := range cols {
+		if _, ok := columns[c]; !ok {
+			return fmt.Errorf("unknown column %q, expected one of %s", c, strings.Join(Columns(), ", "))
+		}
+	}
+	return nil
+}
+
+// Write the list as CSV or TSV with a header row. Every path goes in the same table so there are no
+// per directory headings, and names are quoted as needed so commas, quotes and newlines survive
+func (l *List) printDelimited(w io.Writer) {
+	out := csv.NewWriter(w)
+	if l.Flags.Format == FormatTSV {
+		out.Comma = '\t'
+	}
+	checkErr(out.Write(l.Flags.Columns))
+	for _, base := range l.bases() {
+		for _, file := range l.fileInfos[base] {
+			if l.isHiddenFile(file) && !l.Flags.All {
+				continue
+			}
+			e := l.newEntry(file, base)
+			record := make([]string, len(l.Flags.Columns))
+			for i, c := range l.Flags.Columns {
+				record[i] = columns[c](e)
+			}
+			checkErr(out.Write(record))
+		}
+	}
+	out.Flush()
+	checkErr(out.Error())
+}
+
+// Print the whole list to w. This includes all paths in List
+func (l *List) Print(w io.Writer) {
+	defer l.Flags.Stats.Since(stats.Render, time.Now())
 	l.Sort()
+	if l.Flags.Format == FormatCSV || l.Flags.Format == FormatTSV {
+		l.printDelimited(w)
+		return
+	}
 	// Loop through l.fileInfos and pretty prent the information
 	log.Debug().Msgf("Printing to screen")
 	var count int
-	for base, directory := range l.fileInfos {
+	for _, base := range l.bases() {
+		directory := l.fileInfos[base]
+		if l.Flags.ChangedOnly {
+			directory = l.changedOnly(directory, base)
+			if len(directory) == 0 {
+				continue
+			}
+		}
 		count++
-		columnize.NewAlignRight()
-		if len(l.fileInfos) > 1 {
+		// Sizes are right aligned like ls, everything else is left aligned
+		table := columnize.NewTable(w, !l.Flags.NoColor, 1, columnize.AlignLeft, columnize.AlignLeft, columnize.AlignLeft, columnize.AlignRight)
+		if l.Flags.Template != nil {
+			table = columnize.NewTable(w, !l.Flags.NoColor, 2)
+		}
+		if len(l.fileInfos) > 1 || l.Flags.ChangedOnly {
 			if count > 1 {
-				fmt.Println()
+				fmt.Fprintln(w)
 			}
-			fmt.Println(base + ":")
+			fmt.Fprintln(w, base+":")
 		}
 		for _, file := range directory {
 			var curLine []string
-			if l.Flags.Long {
+			if l.Flags.Template != nil {
+				if !l.isHiddenFile(file) || l.Flags.All {
+					var out strings.Builder
+					checkErr(l.Flags.Template.Execute(&out, l.newEntry(file, base)))
+					table.PrintLine([]string{strings.TrimRight(out.String(), "\n")})
+				}
+			} else if l.Flags.Long {
 				if !l.isHiddenFile(file) || l.Flags.All {
 					curLine = append(curLine, l.getLongListing(file))
-					name, color := l.getProcessedFilename(file, base)
+					if l.indicator() == IndicatorSymbol {
+						curLine = append(curLine, l.getSymbolColumn(file))
+					}
+					name, color := l.getDisplayName(file, base)
 					curLine = append(curLine, name)
-					columnize.PrintLine(
+					nameIdx := len(curLine) - 1
+					if l.Flags.Why {
+						curLine = append(curLine, l.getWhy(file, base))
+					}
+					table.PrintLine(
 						columnize.ColumnizeRow(
 							color,
-							len(curLine)-1,
+							nameIdx,
 							curLine))
 				}
 			} else {
 				if !l.isHiddenFile(file) || l.Flags.All {
-					name, color := l.getProcessedFilename(file, base)
+					name, color := l.getDisplayName(file, base)
+					if l.indicator() == IndicatorSymbol {
+						curLine = append(curLine, l.getSymbolColumn(file))
+					}
 					// not -l so print in columns
 					curLine = append(curLine, name)
-					columnize.PrintLine(
+					nameIdx := len(curLine) - 1
+					if l.Flags.Why {
+						curLine = append(curLine, l.getWhy(file, base))
+					}
+					table.PrintLine(
 						columnize.ColumnizeRow(
 							color,
-							len(curLine)-1,
+							nameIdx,
 							curLine))
 				}
 			}
 		}
-		columnize.Flush()
+		checkErr(table.Flush())
+	}
+}
+
+// The entries of directory listed in Flags.Changed
+func (l *List) changedOnly(directory []fileInfoAttr, base string) []fileInfoAttr {
+	var changed []fileInfoAttr
+	for _, file := range directory {
+		if l.Flags.Changed[entryPath(file, base)] {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}
+
+// Options for List.Watch
+type WatchOptions struct {
+	Interval time.Duration
+	// Redraw the whole listing in place on every refresh. Otherwise the listing is printed once and
+	// after that only the entries that changed, which suits pipes and log files
+	Redraw bool
+	// Stop once every file is on disk, i.e. resident or premigrated
+	UntilResident bool
+	// Called after every refresh when set, e.g. to save the cache since watching usually ends with Ctrl-C
+	Refreshed func()
+}
+
+// Clear the screen and move the cursor to the top left
+const clearScreen = "\x1b[H\x1b[2J"
+
+// Re-stat and print the listing every opts.Interval, highlighting entries whose storage state changed
+// since the previous refresh. Only returns when opts.UntilResident is set and everything is on disk
+func (l *List) Watch(w io.Writer, opts WatchOptions) {
+	var prev map[string]XAttr
+	for {
+		l.StatAll()
+		states := l.states()
+		l.Flags.Changed = changedStates(prev, states)
+		switch {
+		case opts.Redraw:
+			fmt.Fprint(w, clearScreen)
+			fmt.Fprintf(w, "Every %s: %s\n\n", opts.Interval, time.Now().Format(time.RFC1123))
+			l.Print(w)
+		case prev == nil:
+			l.Print(w)
+		case len(l.Flags.Changed) > 0:
+			fmt.Fprintf(w, "\n%s:\n", time.Now().Format(time.RFC1123))
+			l.Flags.ChangedOnly = true
+			l.Print(w)
+			l.Flags.ChangedOnly = false
+		}
+		prev = states
+		if opts.Refreshed != nil {
+			opts.Refreshed()
+		}
+		if opts.UntilResident && allOnDisk(states) {
+			return
+		}
+		time.Sleep(opts.Interval)
+	}
+}
+
+// Storage state of every listed file that has one, by path
+func (l *List) states() map[string]XAttr {
+	states := make(map[string]XAttr)
+	for base, directory := range l.fileInfos {
+		for _, file := range directory {
+			if file.State >= 0 && !file.FileInfo.IsDir() {
+				states[entryPath(file, base)] = file.State
+			}
+		}
+	}
+	return states
+}
+
+// Paths whose state differs between prev and cur. Nothing has changed on the first refresh, when prev is nil
+func changedStates(prev, cur map[string]XAttr) map[string]bool {
+	changed := make(map[string]bool)
+	if prev == nil {
+		return changed
+	}
+	for path, state := range cur {
+		if old, ok := prev[path]; ok && old != state {
+			changed[path] = true
+		}
+	}
+	return changed
+}
+
+func allOnDisk(states map[string]XAttr) bool {
+	for _, state := range states {
+		if state != Ret0 && state != Ret1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Everything gls knows about a single file. Produced by StatFile for `gls :stat`. The fields of storage.Entry come
+// first so the JSON uses the same names and state values as the storage library and `gls :serve`
+type StatReport struct {
+	storage.Entry
+	Type              string            `json:"type"`
+	Permissions       string            `json:"permissions"`
+	Device            uint64            `json:"device"`
+	Inode             uint64            `json:"inode"`
+	Links             uint64            `json:"links"`
+	UID               uint32            `json:"uid"`
+	GID               uint32            `json:"gid"`
+	Owner             string            `json:"owner"`
+	Group             string            `json:"group"`
+	Blocks            int64             `json:"blocks"`
+	BlockSize         int64             `json:"block_size"`
+	Atime             time.Time         `json:"atime"`
+	Ctime             time.Time         `json:"ctime"`
+	MigrationTimes    map[string]string `json:"migration_times,omitempty"`
+	HsmAttributes     map[string]string `json:"hsm_attributes,omitempty"`
+	TooLargeToMigrate bool              `json:"too_large_to_migrate"`
+	MountPoint        string            `json:"mount_point"`
+	Filesystem        string            `json:"filesystem"`
+	FilesystemType    string            `json:"filesystem_type"`
+}
+
+// A single line of /proc/self/mountinfo
+type mountEntry struct {
+	MountPoint string
+	FsType     string
+	Source     string
+}
+
+// Attribute names written by Spectrum Archive/GPFS start with IBM (IBMObj, IBMPMig, IBMTPS, ...)
+var hsmAttrNameRex = regexp.MustCompile(`^IBM[A-Za-z]+`)
+
+// Split the raw attribute string returned by attr_dump into name -> value. Since attr_dump throws away
+// non-printable characters the value is whatever text follows the name up to the next attribute name
+func parseHsmAttrs(raw string) map[string]string {
+	attrs := make(map[string]string)
+	var cur string
+	for _, tok := range strings.Split(raw, "|") {
+		if name := hsmAttrNameRex.FindString(tok); len(name) > 0 {
+			cur = name
+			attrs[cur] = strings.TrimSpace(tok[len(name):])
+		} else if len(cur) > 0 && len(tok) > 0 {
+			if len(attrs[cur]) > 0 {
+				attrs[cur] += "|"
+			}
+			attrs[cur] += tok
+		}
+	}
+	return attrs
+}
+
+// Decode the attributes listed in config.HsmTimestampAttrs that hold a Unix timestamp
+func parseMigrationTimes(attrs map[string]string) map[string]string {
+	times := make(map[string]string)
+	for _, name := range config.HsmTimestampAttrs {
+		secs, err := strconv.ParseInt(strings.TrimSpace(attrs[name]), 10, 64)
+		if err != nil {
+			continue
+		}
+		times[name] = time.Unix(secs, 0).Format(time.RFC3339)
+	}
+	if len(times) == 0 {
+		return nil
+	}
+	return times
+}
+
+// Parse the contents of /proc/self/mountinfo. See proc(5) for the format
+func parseMountInfo(r io.Reader) []mountEntry {
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			// The optional fields are terminated by a lone hyphen, followed by fstype and source
+			if field == "-" && i+2 < len(fields) && len(fields) > 4 {
+				mounts = append(mounts, mountEntry{
+					MountPoint: unescapeMountPath(fields[4]),
+					FsType:     fields[i+1],
+					Source:     unescapeMountPath(fields[i+2]),
+				})
+				break
+			}
+		}
+	}
+	return mounts
+}
+
+// Spaces, tabs, newlines and backslashes are octal escaped in mountinfo (e.g. \040)
+func unescapeMountPath(p string) string {
+	if !strings.Contains(p, "\\") {
+		return p
+	}
+	var out []byte
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+3 < len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(c))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, p[i])
+	}
+	return string(out)
+}
+
+// Find the mount with the longest mount point containing path
+func findMount(mounts []mountEntry, path string) mountEntry {
+	var best mountEntry
+	for _, m := range mounts {
+		if path != m.MountPoint && m.MountPoint != "/" && !strings.HasPrefix(path, m.MountPoint+"/") {
+			continue
+		}
+		if len(m.MountPoint) >= len(best.MountPoint) {
+			best = m
+		}
+	}
+	return best
+}
+
+// Human readable file type as shown by stat(1)
+func fileTypeString(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character special file"
+	case mode&os.ModeDevice != 0:
+		return "block special file"
+	default:
+		return "regular file"
+	}
+}
+
+// Symbol used for a storage state with --indicator=symbol; empty if the state is unknown
+func stateSymbol(state XAttr) string {
+	switch state {
+	case Ret0:
+		return config.Ret0Symbol
+	case Ret1:
+		return config.Ret1Symbol
+	case Ret2:
+		return config.Ret2Symbol
+	default:
+		return ""
+	}
+}
+
+// Text used for a storage state when colors are disabled; empty if the state is unknown
+func stateString(state XAttr) string {
+	switch state {
+	case Ret0:
+		return config.Ret0Str
+	case Ret1:
+		return config.Ret1Str
+	case Ret2:
+		return config.Ret2Str
+	default:
+		return ""
+	}
+}
+
+// Gather everything we know about a single file on fs, or on the operating system's filesystem when fs is nil.
+// onGpfs says whether path lives under config.GpfsRoots and so whether the GPFS attributes should be read
+func StatFile(fs afero.Fs, path string, onGpfs bool, debug bool) StatReport {
+	l := New([]string{path})
+	l.SetFs(fs)
+	l.SetFlags(Flags{
+		Color: map[string]bool{path: onGpfs},
+		Pools: true,
+		Debug: debug,
+	})
+	l.limiter = throttle.Default()
+	fia := l.doFileStat(path, path)
+	st := sysStat(fia.FileInfo)
+
+	r := StatReport{
+		Entry: storage.Entry{
+			Path:       path,
+			Name:       fia.FileInfo.Name(),
+			Size:       fia.Size,
+			Mode:       fia.FileInfo.Mode(),
+			ModTime:    time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec)),
+			IsDir:      fia.FileInfo.IsDir(),
+			OnGPFS:     onGpfs,
+			State:      storage.State(fia.State),
+			Pool:       fia.Pool,
+			TapeCopies: fia.TapeCopies,
+			Target:     fia.Target,
+			Broken:     fia.Broken,
+		},
+		Type:              fileTypeString(fia.FileInfo.Mode()),
+		Permissions:       fmt.Sprintf("%04o", uint32(st.Mode)&07777),
+		Device:            uint64(st.Dev),
+		Inode:             uint64(st.Ino),
+		Links:             uint64(st.Nlink),
+		UID:               st.Uid,
+		GID:               st.Gid,
+		Owner:             fia.Username,
+		Group:             fia.Groupname,
+		Blocks:            int64(st.Blocks),
+		BlockSize:         int64(st.Blksize),
+		Atime:             time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)),
+		Ctime:             time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)),
+		TooLargeToMigrate: bytesToGB(fia.Size) > config.MaxFileSizeGB && !config.DisableSizeChecking,
+	}
+	if onGpfs && !fia.FileInfo.IsDir() {
+		r.HsmAttributes = parseHsmAttrs(l.attrDump(path))
+		r.MigrationTimes = parseMigrationTimes(r.HsmAttributes)
+	}
+
+	// Other filesystems aren't mounted anywhere
+	if _, ok := l.filesystem().(*afero.OsFs); !ok {
+		return r
+	}
+	if f, err := os.Open("/proc/self/mountinfo"); err == nil {
+		m := findMount(parseMountInfo(f), path)
+		f.Close()
+		r.MountPoint = m.MountPoint
+		r.Filesystem = m.Source
+		r.FilesystemType = m.FsType
+	}
+	return r
+}
+
+// Print the report as "Field: value" lines, similar to stat(1)
+func (r *StatReport) PrintText(w io.Writer) {
+	const timeFmt = "2006-01-02 15:04:05.000000000 -0700"
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	rows := [][]string{
+		{"File:", r.Path},
+		{"Type:", r.Type},
+		{"Size:", fmt.Sprintf("%d (%s)", r.Size, columnize.HumanizeSize(r.Size))},
+		{"Blocks:", fmt.Sprintf("%d (IO block %d)", r.Blocks, r.BlockSize)},
+		{"Device:", strconv.FormatUint(r.Device, 10)},
+		{"Inode:", strconv.FormatUint(r.Inode, 10)},
+		{"Links:", strconv.FormatUint(r.Links, 10)},
+		{"Access:", fmt.Sprintf("(%s/%s)", r.Permissions, fileModeToString(r.Mode))},
+		{"Owner:", fmt.Sprintf("%s (%d)", r.Owner, r.UID)},
+		{"Group:", fmt.Sprintf("%s (%d)", r.Group, r.GID)},
+		{"Atime:", r.Atime.Format(timeFmt)},
+		{"Mtime:", r.ModTime.Format(timeFmt)},
+		{"Ctime:", r.Ctime.Format(timeFmt)},
+	}
+	if len(r.MountPoint) != 0 {
+		rows = append(rows, []string{"Mount:", fmt.Sprintf("%s (%s on %s)", r.MountPoint, r.FilesystemType, r.Filesystem)})
+	}
+	rows = append(rows, []string{"GPFS:", yesNo[r.OnGPFS]})
+	if r.OnGPFS {
+		pool := r.Pool
+		if len(pool) == 0 {
+			pool = "-"
+		}
+		// The labels of a listing, rather than the storage.State names the JSON has
+		state := stateString(XAttr(r.State))
+		if len(state) == 0 {
+			state = "-"
+		}
+		rows = append(rows,
+			[]string{"State:", state},
+			[]string{"Premigrated:", yesNo[r.State == storage.Premigrated]},
+			[]string{"Pool:", pool})
+		for i, c := range r.TapeCopies {
+			rows = append(rows, []string{fmt.Sprintf("Tape copy %d:", i+1), fmt.Sprintf("%s (pool %s, library %s)", c.Volser, c.Pool, c.Library)})
+		}
+		for _, name := range config.HsmTimestampAttrs {
+			if t, ok := r.MigrationTimes[name]; ok {
+				rows = append(rows, []string{name + ":", t})
+			}
+		}
+		var names []string
+		for name := range r.HsmAttributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rows = append(rows, []string{"Attr " + name + ":", r.HsmAttributes[name]})
+		}
+	}
+	rows = append(rows, []string{"Too large to migrate:", fmt.Sprintf("%s (limit %d GB)", yesNo[r.TooLargeToMigrate], config.MaxFileSizeGB)})
+
+	table := columnize.NewTable(w, false, 2)
+	for _, row := range rows {
+		table.PrintLine(row)
+	}
+	checkErr(table.Flush())
+}
+
+// Write the report to w as indented JSON
+func (r *StatReport) PrintJSON(w io.Writer) {
+	out, err := json.MarshalIndent(r, "", "  ")
+	checkErr(err)
+	_, err = fmt.Fprintln(w, string(out))
+	checkErr(err)
+}
+
+// How long recalling the migrated files below a set of paths is expected to take. Produced by EstimateRecall for `gls :estimate`
+type RecallEstimate struct {
+	Files            int   `json:"files"`
+	ResidentFiles    int   `json:"resident_files"`
+	PremigratedFiles int   `json:"premigrated_files"`
+	MigratedFiles    int   `json:"migrated_files"`
+	MigratedBytes    int64 `json:"migrated_bytes"`
+	// Distinct tapes holding the migrated files. Migrated files without a recorded tape copy are
+	// counted as if they were all on one extra tape
+	Tapes            int `json:"tapes"`
+	UnknownTapeFiles int `json:"unknown_tape_files"`
+	// Files and directories that couldn't be read, and so aren't counted
+	Errors int `json:"errors"`
+	// Parameters the estimate was made with, from config
+	Drives        int           `json:"drives"`
+	MountTime     time.Duration `json:"mount_time_ns"`
+	SeekTime      time.Duration `json:"seek_time_ns"`
+	DriveMBps     int           `json:"drive_mbps"`
+	Duration      time.Duration `json:"duration_ns"`
+	tapeDurations map[string]time.Duration
+}
+
+// Walk paths and estimate how long a recall of every migrated file below them would take. Each tape costs
+// config.TapeMountTime plus config.TapeSeekTime per file plus the time to read its files at config.TapeDriveMBps,
+// and tapes are spread over config.TapeDrives drives. The paths are read from fs, or from the operating system's
+// filesystem when fs is nil. onGpfs says which paths live under config.GpfsRoots
+func EstimateRecall(fs afero.Fs, paths []string, onGpfs map[string]bool, debug bool) RecallEstimate {
+	l := New(paths)
+	l.SetFs(fs)
+	l.SetFlags(Flags{Color: onGpfs, Pools: true, Debug: debug})
+	l.limiter = throttle.Default()
+	e := RecallEstimate{
+		Drives:        config.TapeDrives,
+		MountTime:     config.TapeMountTime,
+		SeekTime:      config.TapeSeekTime,
+		DriveMBps:     config.TapeDriveMBps,
+		tapeDurations: make(map[string]time.Duration),
+	}
+	for _, root := range paths {
+		err := afero.Walk(l.filesystem(), root, func(path string, info os.FileInfo, err error) error {
+			// Only the size and storage state count, so skip doFileStat's owner lookups
+			if err == nil && info.Mode().IsRegular() {
+				fia := fileInfoAttr{FileInfo: info, State: -1, Size: info.Size()}
+				if l.Flags.Color[root] {
+					l.lookupState(&fia, path, info)
+				}
+				e.add(fia)
+			}
+			if err != nil {
+				// Files can vanish while the tree is walked
+				log.Debug().Msgf("Skipping %s: %s", path, err)
+				e.Errors++
+			}
+			return nil
+		})
+		checkErr(err)
+	}
+	e.Tapes = len(e.tapeDurations)
+	e.Duration = scheduleTapes(e.tapeDurations, e.Drives)
+	return e
+}
+
+// Count a file towards the estimate. A migrated file is read from its first tape copy
+func (e *RecallEstimate) add(f fileInfoAttr) {
+	e.Files++
+	switch f.State {
+	case Ret0:
+		e.ResidentFiles++
+	case Ret1:
+		e.PremigratedFiles++
+	case Ret2:
+		e.MigratedFiles++
+		e.MigratedBytes += f.Size
+		tape := ""
+		if len(f.TapeCopies) > 0 {
+			tape = f.TapeCopies[0].Volser
+		} else {
+			e.UnknownTapeFiles++
+		}
+		if _, ok := e.tapeDurations[tape]; !ok {
+			e.tapeDurations[tape] = e.MountTime
+		}
+		e.tapeDurations[tape] += e.SeekTime
+		if e.DriveMBps > 0 {
+			e.tapeDurations[tape] += time.Duration(float64(f.Size) / float64(e.DriveMBps*1000*1000) * float64(time.Second))
+		}
+	}
+}
+
+// Time until every tape has been read when the longest tapes are handed out first to whichever drive is free
+func scheduleTapes(tapes map[string]time.Duration, drives int) time.Duration {
+	if drives < 1 {
+		drives = 1
+	}
+	var durations []time.Duration
+	for _, d := range tapes {
+		durations = append(durations, d)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] > durations[j] })
+	busy := make([]time.Duration, drives)
+	for _, d := range durations {
+		next := 0
+		for i := range busy {
+			if busy[i] < busy[next] {
+				next = i
+			}
+		}
+		busy[next] += d
+	}
+	var total time.Duration
+	for _, b := range busy {
+		if b > total {
+			total = b
+		}
+	}
+	return total
+}
+
+// Print the estimate as "Field: value" lines
+func (e *RecallEstimate) PrintText(w io.Writer) {
+	tapes := strconv.Itoa(e.Tapes)
+	if e.UnknownTapeFiles > 0 {
+		tapes += fmt.Sprintf(" (%d migrated files have no tape copy recorded)", e.UnknownTapeFiles)
+	}
+	rows := [][]string{
+		{"Files:", strconv.Itoa(e.Files)},
+		{config.Ret0Str + ":", strconv.Itoa(e.ResidentFiles)},
+		{config.Ret1Str + ":", fmt.Sprintf("%d (no recall needed)", e.PremigratedFiles)},
+		{config.Ret2Str + ":", fmt.Sprintf("%d (%s)", e.MigratedFiles, columnize.HumanizeSize(e.MigratedBytes))},
+		{"Tapes:", tapes},
+	}
+	if e.Errors > 0 {
+		rows = append(rows, []string{"Unreadable:", fmt.Sprintf("%d files and directories (not counted)", e.Errors)})
+	}
+	rows = append(rows, []string{"Estimated recall time:", fmt.Sprintf("%s (%d drives, %s mount, %s seek, %d MB/s)", e.Duration.Round(time.Second), e.Drives, e.MountTime, e.SeekTime, e.DriveMBps)})
+	table := columnize.NewTable(w, false, 2)
+	for _, row := range rows {
+		table.PrintLine(row)
+	}
+	checkErr(table.Flush())
+}
+
+// Write the estimate to w as indented JSON
+func (e *RecallEstimate) PrintJSON(w io.Writer) {
+	out, err := json.MarshalIndent(e, "", "  ")
+	checkErr(err)
+	_, err = fmt.Fprintln(w, string(out))
+	checkErr(err)
+}
+
+// A function returning the storage state of the file at a path, with every lookup within one limiter. Only
+// meaningful for files on GPFS
+func FileStates() func(path string) storage.State {
+	l := &List{limiter: throttle.Default()}
+	return func(path string) storage.State {
+		return storage.State(l.attrCheck(path))
 	}
 }
 
-// Wrapper function around C function that calls gpfs_fgetattrs(). The user of this function doesn't need to deal with the C.* functions this way
+// Storage state of the file at path from storage.DefaultBackend, or -1 if it can't be read
 func attr_check(path string) int {
-	cs := C.CString(path)
-	return int(C.attr_check(cs))
+	state, err := storage.DefaultBackend.State(path)
+	if err != nil {
+		log.Debug().Msgf("Unable to read the storage state of %s: %s", path, err)
+		return -1
+	}
+	return int(state)
+}
+
+// Printable HSM attributes of the file with '|' between attributes, or "" if they can't be read
+func attr_dump(path string) string {
+	attrs, err := storage.DefaultBackend.Attributes(path)
+	if err != nil {
+		log.Debug().Msgf("Unable to read the attributes of %s: %s", path, err)
+	}
+	return attrs
+}
+
+// Name of the GPFS storage pool holding the data on disk, or "" if unknown
+func storage_pool(path string) string {
+	pool, err := storage.DefaultBackend.Pool(path)
+	if err != nil {
+		log.Debug().Msgf("Unable to read the storage pool of %s: %s", path, err)
+	}
+	return pool
 }
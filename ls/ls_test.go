@@ -1,30 +1,53 @@
 package ls
 
 import (
-	"testing"
-	"reflect"
-	"sync"
+	"bytes"
+	"github.com/olcf/gls/columnize"
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/fake"
+	"github.com/olcf/gls/policy"
+	"github.com/olcf/gls/stats"
+	"github.com/olcf/gls/storage"
 	"os"
 	"path/filepath"
-	"gls/columnize"
-	"bytes"
-	//"fmt"
-	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
 	"github.com/spf13/afero"
 )
 
 var afs afero.Fs
 
+// /nl/themis holds two visible files and a hidden one, /bin links to /usr/bin like on RHEL. Everything is owned by root
+const testFixture = `{
+	"mtime": "2021-03-31T04:28:00Z",
+	"files": {
+		"/nl/themis/redhat-release": {"size": 45},
+		"/nl/themis/test": {"mtime": "2021-03-31T05:28:00Z"},
+		"/nl/themis/.hidden": {},
+		"/usr/bin": {"type": "dir"},
+		"/bin": {"type": "link", "target": "usr/bin"},
+		"/tmp/loop": {"type": "link", "target": "loop"}
+	}
+}`
+
 func InitFS() {
-	afs = afero.NewMemMapFs()
-	err := afs.MkdirAll("/nl/themis", 0777)
+	fx, err := fake.Parse(strings.NewReader(testFixture))
 	checkErr(err)
-	_, err = afs.Create("/nl/themis/redhat-release")
-	checkErr(err)
-	_, err = afs.Create("/nl/themis/test")
+	afs, err = fx.Fs()
 	checkErr(err)
 }
 
+// A List of paths reading afs
+func newTestList(paths ...string) *List {
+	l := New(paths)
+	l.SetFs(afs)
+	return l
+}
+
 func TestNewLS(t *testing.T) {
 	InitFS()
 	testPath := "/nl/themis/test"
@@ -38,7 +61,7 @@ func TestNewLS(t *testing.T) {
 func TestSetFlags(t *testing.T) {
 	testPath := "/nl/themis/test"
 	testFlags := Flags{
-		Long: true,
+		Long:  true,
 		Debug: true,
 	}
 	want := &List{paths: []string{testPath}, Flags: testFlags}
@@ -61,7 +84,7 @@ func TestBytesToGB(t *testing.T) {
 }
 
 func TestFileStatWorker(t *testing.T) {
-	testList := New([]string{"/nl/themis"})
+	testList := newTestList("/nl/themis")
 	inputChan := make(chan string, 1)
 	outputChan := make(chan fileInfoAttr, 1)
 	base := "/nl/themis"
@@ -70,66 +93,54 @@ func TestFileStatWorker(t *testing.T) {
 	wg.Add(1)
 
 	inputChan <- inputPath
+	close(inputChan)
 
 	go testList.fileStatWorker(inputChan, outputChan, &wg, base)
-	var have fileInfoAttr
-	for out := range outputChan {
-		have = out
-		if len(outputChan) <= 0 {
-			close(outputChan)
-		}
-	}
+	have := <-outputChan
+	wg.Wait()
 	// If the file actually exists then succeed
-	var dontWant os.FileInfo
-	if have.FileInfo == dontWant {
-		t.Fatalf("ls.fileStatWorker(%s) = %v; want len > 0", inputPath, have.FileInfo)
+	if have.FileInfo == nil || have.FileInfo.Name() != "redhat-release" {
+		t.Fatalf("ls.fileStatWorker(%s) = %v; want redhat-release", inputPath, have.FileInfo)
 	}
 }
 
 func TestDoBulkFileStat(t *testing.T) {
-	testList := New([]string{"/"})
+	testList := newTestList("/")
 	base := "/"
 	fileList := []string{"/usr", "/bin"}
 	have := testList.doBulkFileStat(fileList, base)
 	if len(have) != 2 {
-		t.Fatalf("ls.doBulkFileStat(%v) = %v; want len == 0", fileList, have)
+		t.Fatalf("ls.doBulkFileStat(%v) = %v; want len == 2", fileList, have)
 	}
-	var dontWant os.FileInfo
 	for idx, f := range have {
-		if f.FileInfo == dontWant {
+		if f.FileInfo == nil {
 			t.Fatalf("ls.doBulkFileStat(%v)[%d] = %v; want not nil", fileList, idx, f.FileInfo)
 		}
 	}
-
 }
 
 func TestDoFileStat(t *testing.T) {
-	testList := New([]string{"/nl/themis"})
+	testList := newTestList("/nl/themis")
 	have := testList.doFileStat("/nl/themis/redhat-release", "/nl/themis")
 	fileInfo, _ := afs.Stat("/nl/themis/redhat-release")
 
-	// {0xc000188270 root root Mar 31 04:28 2021 -1 45 -rw-r--r--}
 	want := fileInfoAttr{
-		FileInfo: fileInfo,
-		Username: "root",
+		FileInfo:  fileInfo,
+		Username:  "root",
 		Groupname: "root",
-		Mtime: "Mar 31 04:28 2021",
-		State: -1,
-		Size: 45,
-		Mode: "-rw-r--r--",
+		Mtime:     "Mar 31 04:28 2021",
+		State:     -1,
+		Size:      45,
+		Mode:      "-rw-r--r--",
 	}
 
 	if !reflect.DeepEqual(have, want) {
 		t.Fatalf("ls.doFileStat(\"/nl/themis/redhat-release\", \"/nl/themis\") = %v; want %v", have, want)
 	}
-}
 
-func TestHumanizeSize(t *testing.T) {
-	var testVal int64 = 123456
-	have := humanizeSize(testVal)
-	want := "123.5 kB"
-	if have != want {
-		t.Fatalf("ls.humanizeSize(%d) = %s; want %s", testVal, have, want)
+	// The dot entries keep their names
+	if have := testList.doFileStat("/nl/themis/..", "/nl/themis"); have.FileInfo.Name() != ".." || !have.FileInfo.IsDir() {
+		t.Fatalf("ls.doFileStat(\"/nl/themis/..\") = %v; want directory ..", have.FileInfo)
 	}
 }
 
@@ -143,13 +154,13 @@ func TestFileModeToString(t *testing.T) {
 }
 
 func TestIsSymlink(t *testing.T) {
-	fi, _ := afs.Stat("/bin") // /bin on rhel systems appear to be symlinks
+	fi, _ := storage.Lstat(afs, "/bin")
 	have := isSymlink(fi)
 	want := true
 	if have != want {
 		t.Fatalf("ls.isSymlink(%v) = %t; want %t", fi, have, want)
 	}
-	fi, _ = afs.Stat("/nl/themis/redhat-release")
+	fi, _ = storage.Lstat(afs, "/nl/themis/redhat-release")
 	have = isSymlink(fi)
 	want = false
 	if have != want {
@@ -157,21 +168,29 @@ func TestIsSymlink(t *testing.T) {
 	}
 }
 
+func TestResolveLink(t *testing.T) {
+	if have, info, err := storage.ResolveLink(afs, "/bin"); err != nil || have != "/usr/bin" || !info.IsDir() {
+		t.Fatalf("storage.ResolveLink(/bin) = %s, %v; want /usr/bin", have, err)
+	}
+	if have, _, err := storage.ResolveLink(afs, "/tmp/loop"); err == nil {
+		t.Fatalf("storage.ResolveLink(/tmp/loop) = %s; want error", have)
+	}
+}
+
 func TestPopulateMetadata(t *testing.T) {
 	testFile, _ := afs.Stat("/nl/themis/redhat-release")
 	have := fileInfoAttr{
 		FileInfo: testFile,
 	}
 	origHave := have
-	//root root Mar 31 04:28 2021 0 45 -rw-r--r--}
 	want := fileInfoAttr{
-		FileInfo: testFile,
-		Username: "root",
+		FileInfo:  testFile,
+		Username:  "root",
 		Groupname: "root",
-		Mtime: "Mar 31 04:28 2021",
-		State: 0,
-		Size: 45,
-		Mode: "-rw-r--r--",
+		Mtime:     "Mar 31 04:28 2021",
+		State:     0,
+		Size:      45,
+		Mode:      "-rw-r--r--",
 	}
 
 	have.populateMetadata()
@@ -192,10 +211,7 @@ func TestIsHiddenFile(t *testing.T) {
 		t.Fatalf("ls.isHiddenFile(%v) = %t; want %t", test, have, want)
 	}
 
-	f, _ := os.OpenFile("/tmp/.hidden", os.O_RDONLY|os.O_CREATE, 0666)
-	f.Close()
-
-	fi, _ = afs.Stat("/tmp/.hidden")
+	fi, _ = afs.Stat("/nl/themis/.hidden")
 	test = fileInfoAttr{
 		FileInfo: fi,
 	}
@@ -204,21 +220,43 @@ func TestIsHiddenFile(t *testing.T) {
 	if have != want {
 		t.Fatalf("ls.isHiddenFile(%v) = %t; want %t", test, have, want)
 	}
-	_ = os.Remove("/tmp/.hidden")
+}
+
+// Names of the files listed under base
+func listedNames(l *List, base string) []string {
+	var names []string
+	for _, f := range l.fileInfos[base] {
+		names = append(names, f.FileInfo.Name())
+	}
+	return names
 }
 
 func TestStatAll(t *testing.T) {
-	path, _ := filepath.Abs(".")
-	l := New([]string{path})
+	path := "/nl/themis"
+	l := newTestList(path)
 	l.StatAll()
 	if len(l.fileInfos[path]) != 2 {
 		t.Fatalf("ls.StatAll(%s) = %v; want len == 2", path, l.fileInfos[path])
 	}
+
+	l.SetFlags(Flags{All: true})
+	l.StatAll()
+	l.Sort()
+	want := []string{".", "..", ".hidden", "redhat-release", "test"}
+	if have := listedNames(l, path); !reflect.DeepEqual(have, want) {
+		t.Fatalf("ls(flags=all).StatAll(%s) = %v; want %v", path, have, want)
+	}
+
+	l.SetFlags(Flags{Directory: true})
+	l.StatAll()
+	if have := listedNames(l, "/nl"); !reflect.DeepEqual(have, []string{"themis"}) {
+		t.Fatalf("ls(flags=directory).StatAll(%s) = %v; want [themis]", path, have)
+	}
 }
 
 func TestGetSymlinkString(t *testing.T) {
-	fi, _ := afs.Stat("/bin")
-	l := List{}
+	l := newTestList("/")
+	fi := l.doFileStat("/bin", "/")
 	have := []byte(string([]rune(l.getSymlinkString(fi, "/"))))
 	want := []byte{27, 91, 48, 48, 48, 48, 51, 54, 109, 98, 105, 110, 27, 91, 48, 48, 48, 48, 48, 48, 109}
 	if !bytes.Equal(have, want) {
@@ -238,12 +276,12 @@ func TestGetSymlinkString(t *testing.T) {
 
 // TODO: add test for long filename
 func TestGetProcessedFileName(t *testing.T) {
-	base, err := filepath.Abs(".")
-	checkErr(err)
-	l := New([]string{base})
+	base := "/nl/themis"
+	l := newTestList(base)
 	l.StatAll()
+	l.Sort()
 	str, color := l.getProcessedFilename(l.fileInfos[base][0], base)
-	wantStr := "ls.go"
+	wantStr := "redhat-release"
 	wantColor := columnize.Reset
 	if str != wantStr {
 		t.Fatalf("ls(flags=long).getProcessedFilename(%s) = %s; want %s", base, str, wantStr)
@@ -253,66 +291,56 @@ func TestGetProcessedFileName(t *testing.T) {
 	}
 }
 
+func TestEntryPath(t *testing.T) {
+	l := newTestList("/")
+	l.StatAll()
+	for _, file := range l.fileInfos["/"] {
+		want := "/" + file.FileInfo.Name()
+		if have := entryPath(file, "/"); have != want {
+			t.Fatalf("ls.entryPath(%s, /) = %s; want %s", file.FileInfo.Name(), have, want)
+		}
+	}
+}
 
 func TestSort(t *testing.T) {
-	path, _ := filepath.Abs(".")
-	l := New([]string{path})
+	path := "/nl/themis"
+	l := newTestList(path)
+	l.SetFlags(Flags{All: true})
 	l.StatAll()
 	l.Sort()
-	if l.fileInfos[path][0].FileInfo.Name() != "ls.go" && l.fileInfos[path][len(l.fileInfos)-1].FileInfo.Name() != "ls_test.go" {
-		t.Fatalf("ls.Sort(%s) = %v; want [0] == 'ls.go' && [1] == 'ls_test.go'", path, l.fileInfos[path])
+	if have, want := listedNames(l, path), []string{".", "..", ".hidden", "redhat-release", "test"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("ls.Sort(%s) = %v; want %v", path, have, want)
 	}
-}
 
-func captureOutput(f func()) string {
-	reader, writer, err := os.Pipe()
-	if err != nil {
-		panic(err)
-	}
-	stdout := os.Stdout
-	stderr := os.Stderr
-	defer func() {
-		os.Stdout = stdout
-		os.Stderr = stderr
-	}()
-	os.Stdout = writer
-	os.Stderr = writer
-	out := make(chan string)
-	wg := new(sync.WaitGroup)
-	wg.Add(1)
-	go func() {
-		var buf bytes.Buffer
-		wg.Done()
-		io.Copy(&buf, reader)
-		out <- buf.String()
-	}()
-	wg.Wait()
-	f()
-	writer.Close()
-	return <-out
+	// Oldest first
+	l.SetFlags(Flags{SortByTime: true})
+	l.StatAll()
+	l.Sort()
+	if have, want := listedNames(l, path), []string{"redhat-release", "test"}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("ls(flags=time).Sort(%s) = %v; want %v", path, have, want)
+	}
 }
 
 func TestPrint(t *testing.T) {
-	path, err := filepath.Abs(".")
-	checkErr(err)
-	l := New([]string{path})
-	//TODO: Figure out how to set -l without being bitten by the mtime changing on files resulting in different output
-//	flags := Flags{
-//		Long: true,
-//	}
-//
-//	l.SetFlags(flags)
+	path := "/nl/themis"
+	l := newTestList(path)
 	l.StatAll()
 	l.Sort()
 
-	output := captureOutput(
-		func() {
-			l.Print()
-	})
-	have := []byte(string([]rune(output)))
-	want := []byte{27, 91, 48, 48, 48, 48, 48, 48, 109, 108, 115, 46, 103, 111, 27, 91, 48, 48, 48, 48, 48, 48, 109, 10, 27, 91, 48, 48, 48, 48, 48, 48, 109, 108, 115, 95, 116, 101, 115, 116, 46, 103, 111, 27, 91, 48, 48, 48, 48, 48, 48, 109, 10}
-	if !bytes.Equal(have, want) {
-		t.Fatalf("ls.Print(%s) = %v; want %v", path, have, want)
+	var buf bytes.Buffer
+	l.Print(&buf)
+	want := columnize.Colorize(columnize.Reset, "redhat-release") + "\n" + columnize.Colorize(columnize.Reset, "test") + "\n"
+	if have := buf.String(); have != want {
+		t.Fatalf("ls.Print(%s) = %q; want %q", path, have, want)
+	}
+
+	l.SetFlags(Flags{Long: true, NoColor: true})
+	buf.Reset()
+	l.Print(&buf)
+	want = "-rw-r--r-- root root 45 Mar 31 04:28 2021 redhat-release\n" +
+		"-rw-r--r-- root root  0 Mar 31 05:28 2021 test\n"
+	if have := buf.String(); have != want {
+		t.Fatalf("ls(flags=long,nocolor).Print(%s) = %q; want %q", path, have, want)
 	}
 }
 
@@ -321,3 +349,393 @@ func TestAttrCheck(t *testing.T) {
 	//Maybe here i just need to embed some files that have the extended gpfs attribute?
 	//That might not work. This assumes that go:embed, git and nfs preserve gpfs extended attrs
 }
+
+func TestMissingTapeCopies(t *testing.T) {
+	f := fileInfoAttr{State: Ret2, TapeCopies: []TapeCopy{{Volser: "JD0147JD", Pool: "primary"}}}
+	if !f.missingTapeCopies() {
+		t.Fatalf("ls.missingTapeCopies(%v) = false; want true", f)
+	}
+	f.State = Ret0
+	if f.missingTapeCopies() {
+		t.Fatalf("ls.missingTapeCopies(%v) = true; want false", f)
+	}
+}
+
+func TestParseHsmAttrs(t *testing.T) {
+	raw := "IBMObj|8|IBMPMig|IBMTPS2 JD0147JD@primary@lib1"
+	have := parseHsmAttrs(raw)
+	want := map[string]string{
+		"IBMObj":  "8",
+		"IBMPMig": "",
+		"IBMTPS":  "2 JD0147JD@primary@lib1",
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("ls.parseHsmAttrs(%s) = %v; want %v", raw, have, want)
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mountinfo := `22 1 253:0 / / rw,relatime shared:1 - xfs /dev/mapper/root rw
+98 22 0:52 / /nl/themis rw,relatime shared:40 - gpfs themis rw
+99 22 0:53 / /nl/themis\040scratch rw,relatime - nfs server:/scratch rw
+`
+	mounts := parseMountInfo(strings.NewReader(mountinfo))
+	if len(mounts) != 3 {
+		t.Fatalf("ls.parseMountInfo() = %v; want len == 3", mounts)
+	}
+	tests := map[string]mountEntry{
+		"/nl/themis/proj/file":    {MountPoint: "/nl/themis", FsType: "gpfs", Source: "themis"},
+		"/nl/themis scratch/file": {MountPoint: "/nl/themis scratch", FsType: "nfs", Source: "server:/scratch"},
+		"/nl/themisother":         {MountPoint: "/", FsType: "xfs", Source: "/dev/mapper/root"},
+	}
+	for path, want := range tests {
+		have := findMount(mounts, path)
+		if have != want {
+			t.Fatalf("ls.findMount(%s) = %v; want %v", path, have, want)
+		}
+	}
+}
+
+func TestDirStateOf(t *testing.T) {
+	fx, err := fake.Parse(strings.NewReader(`{"files": {
+		"/proj/empty": {"type": "dir"},
+		"/proj/tape/a": {"state": "migrated"},
+		"/proj/tape/sub/b": {"state": "migrated"},
+		"/proj/mixed/a": {"state": "resident"},
+		"/proj/mixed/b": {"state": "premigrated"},
+		"/proj/deep/file": {},
+		"/proj/deep/sub/sub/sub/file": {"state": "migrated"}
+	}}`))
+	checkErr(err)
+	fs, err := fx.Fs()
+	checkErr(err)
+	defer func(backend storage.Backend) { storage.DefaultBackend = backend }(storage.DefaultBackend)
+	storage.DefaultBackend = fx.Backend()
+
+	l := New(nil)
+	l.SetFs(fs)
+	l.Flags.Stats = stats.New()
+	l.dirStateDeadline = time.Now().Add(time.Minute)
+	tests := []struct {
+		dir     string
+		state   DirState
+		partial bool
+	}{
+		{"/proj/empty", DirEmpty, false},
+		{"/proj/tape", DirMigrated, false},
+		{"/proj/mixed", DirMixed, false},
+		// The migrated file is deeper than config.DirStateMaxDepth
+		{"/proj/deep", DirResident, true},
+	}
+	for _, test := range tests {
+		state, partial := l.dirStateOf(test.dir)
+		if state != test.state || partial != test.partial {
+			t.Fatalf("ls.List.dirStateOf(%s) = %d, %t; want %d, %t", test.dir, state, partial, test.state, test.partial)
+		}
+	}
+
+	// Every file within config.DirStateMaxDepth was checked
+	for _, p := range l.Flags.Stats.Report().Phases {
+		if p.Name == stats.Attr && p.Calls != 5 {
+			t.Fatalf("ls.List.dirStateOf() recorded %v %v calls; want %v", p.Calls, stats.Attr, 5)
+		}
+	}
+
+	l.dirStateDeadline = time.Now().Add(-time.Second)
+	state, partial := l.dirStateOf("/proj/tape")
+	if state != DirUnknown || !partial {
+		t.Fatalf("ls.List.dirStateOf(/proj/tape) = %d, %t; want %d, true", state, partial, DirUnknown)
+	}
+}
+
+func TestBrokenSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "dangling")
+	checkErr(os.Symlink(filepath.Join(dir, "missing"), link))
+	l := New([]string{dir})
+	l.SetFlags(Flags{Long: true, Color: map[string]bool{dir: true}})
+	fi := l.doFileStat(link, dir)
+	if !fi.Broken || fi.State != -1 {
+		t.Fatalf("ls.doFileStat(%s) = %v; want Broken == true && State == -1", link, fi)
+	}
+	have := l.getSymlinkString(fi, dir)
+	want := columnize.Colorize(columnize.Orphan, "dangling") + " -> " + columnize.Colorize(columnize.Orphan, "./missing")
+	if have != want {
+		t.Fatalf("ls.getSymlinkString(%s) = %q; want %q", link, have, want)
+	}
+
+	l.SetFlags(Flags{Long: true, NoColor: true, Dereference: true})
+	fi = l.doFileStat(link, dir)
+	have = l.getSymlinkString(fi, dir)
+	want = "(" + config.BrokenLinkStr + ") dangling -> ./missing"
+	if have != want {
+		t.Fatalf("ls(flags=nocolor,dereference).getSymlinkString(%s) = %q; want %q", link, have, want)
+	}
+}
+
+func TestGetFileColor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.tar")
+	checkErr(os.WriteFile(path, []byte("data"), 0644))
+	fi, _ := os.Lstat(path)
+	file := fileInfoAttr{FileInfo: fi, State: Ret2}
+	theme := columnize.DefaultTheme
+	l := List{Flags: Flags{
+		Theme:    &theme,
+		LSColors: columnize.ParseLSColors("fi=00:*.tar=01;31"),
+	}}
+
+	tests := map[columnize.Precedence]columnize.Color{
+		columnize.PrecedenceState:      theme.Migrated,
+		columnize.PrecedenceLSColors:   columnize.SGR("01;31"),
+		columnize.PrecedenceBackground: columnize.SGR("01;31") + theme.MigratedBg,
+	}
+	for precedence, want := range tests {
+		theme.Precedence = precedence
+		if have := l.getFileColor(file); have != want {
+			t.Fatalf("ls(precedence=%s).getFileColor(%s) = %q; want %q", precedence, path, have, want)
+		}
+	}
+
+	// Without a storage state LS_COLORS is used as is
+	file.State = -1
+	if have := l.getFileColor(file); have != columnize.SGR("01;31") {
+		t.Fatalf("ls.getFileColor(%s) = %q; want %q", path, have, columnize.SGR("01;31"))
+	}
+}
+
+func TestLinkTargetColor(t *testing.T) {
+	dir := t.TempDir()
+	checkErr(os.WriteFile(filepath.Join(dir, "data.tar"), []byte("data"), 0644))
+	checkErr(os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	checkErr(os.Symlink("data.tar", filepath.Join(dir, "tar-link")))
+	checkErr(os.Symlink("sub", filepath.Join(dir, "dir-link")))
+	theme := columnize.DefaultTheme
+	l := New([]string{dir})
+
+	tests := []struct {
+		lsColors string
+		link     string
+		want     columnize.Color
+	}{
+		{"ln=01;36:*.tar=01;31", "tar-link", columnize.SGR("01;36")},
+		{"ln=target:*.tar=01;31", "tar-link", columnize.SGR("01;31")},
+		{"ln=target:di=01;34", "dir-link", columnize.SGR("01;34")},
+		// Nothing in LS_COLORS for directories, so the theme's
+		{"ln=target", "dir-link", theme.Directory},
+	}
+	for _, test := range tests {
+		l.SetFlags(Flags{Theme: &theme, LSColors: columnize.ParseLSColors(test.lsColors)})
+		fi := l.doFileStat(filepath.Join(dir, test.link), dir)
+		if have := l.getTypeColor(fi); have != test.want {
+			t.Fatalf("ls(LS_COLORS=%s).getTypeColor(%s) = %q; want %q", test.lsColors, test.link, have, test.want)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	l := List{Flags: Flags{NoColor: true}}
+	if have := l.annotate("file", config.Ret2Str, config.Ret2Symbol); have != "("+config.Ret2Str+") file" {
+		t.Fatalf("ls(flags=nocolor).annotate(file) = %q; want %q", have, "("+config.Ret2Str+") file")
+	}
+	l.SetFlags(Flags{NoColor: true, Indicator: IndicatorNone})
+	if have := l.annotate("file", config.Ret2Str, config.Ret2Symbol); have != "file" {
+		t.Fatalf("ls(indicator=none).annotate(file) = %q; want %q", have, "file")
+	}
+	l.SetFlags(Flags{Indicator: IndicatorSuffix})
+	if have := l.annotate("file", config.Ret2Str, config.Ret2Symbol); have != "file"+config.Ret2Suffix {
+		t.Fatalf("ls(indicator=suffix).annotate(file) = %q; want %q", have, "file"+config.Ret2Suffix)
+	}
+
+	// The symbol goes in its own column instead
+	l.SetFlags(Flags{Indicator: IndicatorSymbol})
+	if have := l.annotate("file", config.Ret2Str, config.Ret2Symbol); have != "file" {
+		t.Fatalf("ls(indicator=symbol).annotate(file) = %q; want %q", have, "file")
+	}
+	fi, _ := os.Lstat(t.TempDir())
+	if have := l.getSymbolColumn(fileInfoAttr{FileInfo: fi, State: -1}); have != " " {
+		t.Fatalf("ls(indicator=symbol).getSymbolColumn(dir) = %q; want %q", have, " ")
+	}
+	l.SetFlags(Flags{Indicator: IndicatorSymbol, DirState: true})
+	if have := l.getSymbolColumn(fileInfoAttr{FileInfo: fi, DirState: DirMixed}); have != config.DirMixedSymbol {
+		t.Fatalf("ls(indicator=symbol).getSymbolColumn(dir) = %q; want %q", have, config.DirMixedSymbol)
+	}
+
+	// Directories the budget ran out on before any file was checked
+	unchecked := fileInfoAttr{FileInfo: fi, DirState: DirUnknown, DirPartial: true}
+	l.SetFlags(Flags{DirState: true})
+	if have, _ := l.getProcessedFilename(unchecked, ""); have != fi.Name()+"?" {
+		t.Fatalf("ls(dirstate).getProcessedFilename(unchecked) = %q; want %q", have, fi.Name()+"?")
+	}
+	l.SetFlags(Flags{NoColor: true, DirState: true, Indicator: IndicatorText})
+	want := "(" + config.DirNotCheckedStr + ") " + fi.Name()
+	if have, _ := l.getProcessedFilename(unchecked, ""); have != want {
+		t.Fatalf("ls(dirstate,indicator=text).getProcessedFilename(unchecked) = %q; want %q", have, want)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "data.tar")
+	checkErr(os.WriteFile(file, []byte("hello"), 0644))
+	l := New([]string{dir})
+	l.SetFlags(Flags{NoColor: true, Indicator: IndicatorText})
+	fi := l.doFileStat(file, dir)
+	fi.State = Ret2
+	fi.TapeCopies = []TapeCopy{{Volser: "JD0147JD"}, {Volser: "JD0212JD"}}
+
+	tmpl, err := NewTemplate("{{human .Size}}\t{{.State}}\t{{join .TapeIDs \",\"}}\t{{.Path}}\t{{.Display}}")
+	if err != nil {
+		t.Fatalf("ls.NewTemplate() = %s; want nil", err)
+	}
+	var have strings.Builder
+	checkErr(tmpl.Execute(&have, l.newEntry(fi, dir)))
+	want := "5 B\t" + config.Ret2Str + "\tJD0147JD,JD0212JD\t" + file + "\t(" + config.Ret2Str + ") data.tar"
+	if have.String() != want {
+		t.Fatalf("ls.newEntry(%s) executed = %q; want %q", file, have.String(), want)
+	}
+
+	if _, err := NewTemplate("{{.Size"); err == nil {
+		t.Fatalf("ls.NewTemplate(%q) = nil; want error", "{{.Size")
+	}
+}
+
+func TestPrintDelimited(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a,\"b\".txt")
+	checkErr(os.WriteFile(file, []byte("hello"), 0644))
+	l := New([]string{dir})
+	fi := l.doFileStat(file, dir)
+	fi.State = Ret1
+	l.fileInfos = map[string][]fileInfoAttr{dir: {fi}}
+	mtime := fi.FileInfo.ModTime().Format(time.RFC3339)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatCSV, "name,size,state,mtime\n\"a,\"\"b\"\".txt\",5," + config.Ret1Str + "," + mtime + "\n"},
+		{FormatTSV, "name\tsize\tstate\tmtime\n\"a,\"\"b\"\".txt\"\t5\t" + config.Ret1Str + "\t" + mtime + "\n"},
+	}
+	for _, test := range tests {
+		l.SetFlags(Flags{Format: test.format, Columns: []string{"name", "size", "state", "mtime"}})
+		var buf bytes.Buffer
+		l.Print(&buf)
+		if have := buf.String(); have != test.want {
+			t.Fatalf("ls(format=%s).Print(%s) = %q; want %q", test.format, dir, have, test.want)
+		}
+	}
+
+	if err := CheckColumns([]string{"name", "bogus"}); err == nil {
+		t.Fatalf("ls.CheckColumns(bogus) = nil; want error")
+	}
+}
+
+func TestChangedStates(t *testing.T) {
+	prev := map[string]XAttr{"/a": Ret0, "/b": Ret2, "/c": Ret2}
+	cur := map[string]XAttr{"/a": Ret0, "/b": Ret1, "/d": Ret2}
+	want := map[string]bool{"/b": true}
+	if have := changedStates(prev, cur); !reflect.DeepEqual(have, want) {
+		t.Fatalf("ls.changedStates(%v, %v) = %v; want %v", prev, cur, have, want)
+	}
+	if have := changedStates(nil, cur); len(have) != 0 {
+		t.Fatalf("ls.changedStates(nil, %v) = %v; want empty", cur, have)
+	}
+	if allOnDisk(cur) {
+		t.Fatalf("ls.allOnDisk(%v) = true; want false", cur)
+	}
+	if on := map[string]XAttr{"/a": Ret0, "/b": Ret1}; !allOnDisk(on) {
+		t.Fatalf("ls.allOnDisk(%v) = false; want true", on)
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	checkErr(os.WriteFile(file, nil, 0644))
+	l := New([]string{dir})
+	l.SetFlags(Flags{NoColor: true, Changed: map[string]bool{file: true}})
+	fi := l.doFileStat(file, dir)
+	if have, _ := l.getDisplayName(fi, dir); have != "file ("+config.ChangedStr+")" {
+		t.Fatalf("ls(changed).getDisplayName(%s) = %q; want %q", file, have, "file ("+config.ChangedStr+")")
+	}
+	l.SetFlags(Flags{Changed: map[string]bool{file: true}})
+	if _, have := l.getDisplayName(fi, dir); !strings.HasPrefix(string(have), columnize.Reverse) {
+		t.Fatalf("ls(changed).getDisplayName(%s) color = %q; want reverse video", file, have)
+	}
+}
+
+func TestEstimateRecall(t *testing.T) {
+	e := RecallEstimate{
+		Drives:        2,
+		MountTime:     100 * time.Second,
+		SeekTime:      10 * time.Second,
+		DriveMBps:     100,
+		tapeDurations: make(map[string]time.Duration),
+	}
+	files := []fileInfoAttr{
+		{State: Ret0, Size: 1},
+		{State: Ret1, Size: 1},
+		{State: Ret2, Size: 500 * 1000 * 1000, TapeCopies: []TapeCopy{{Volser: "A00001"}, {Volser: "B00001"}}},
+		{State: Ret2, Size: 500 * 1000 * 1000, TapeCopies: []TapeCopy{{Volser: "A00001"}}},
+		{State: Ret2, Size: 0, TapeCopies: []TapeCopy{{Volser: "A00002"}}},
+		{State: Ret2, Size: 0},
+	}
+	for _, f := range files {
+		e.add(f)
+	}
+	if e.Files != 6 || e.ResidentFiles != 1 || e.PremigratedFiles != 1 || e.MigratedFiles != 4 || e.MigratedBytes != 1000*1000*1000 || e.UnknownTapeFiles != 1 {
+		t.Fatalf("ls.RecallEstimate.add() = %+v; want 6 files, 1 resident, 1 premigrated, 4 migrated, 1 GB, 1 unknown", e)
+	}
+	// A00001: 100s mount + 2*10s seek + 10s read; A00002 and the unknown tape: 110s each
+	want := map[string]time.Duration{"A00001": 130 * time.Second, "A00002": 110 * time.Second, "": 110 * time.Second}
+	if !reflect.DeepEqual(e.tapeDurations, want) {
+		t.Fatalf("ls.RecallEstimate.add() tapes = %v; want %v", e.tapeDurations, want)
+	}
+	if have := scheduleTapes(e.tapeDurations, e.Drives); have != 220*time.Second {
+		t.Fatalf("ls.scheduleTapes(%v, 2) = %s; want 220s", e.tapeDurations, have)
+	}
+	if have := scheduleTapes(e.tapeDurations, 0); have != 350*time.Second {
+		t.Fatalf("ls.scheduleTapes(%v, 0) = %s; want 350s", e.tapeDurations, have)
+	}
+}
+
+func TestEstimateRecallErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "orphan")
+	checkErr(os.WriteFile(file, []byte("hello"), 0644))
+	// An owner without a passwd entry doesn't matter to the estimate
+	if err := os.Chown(file, 54321, 54321); err != nil {
+		t.Skip("can't chown:", err)
+	}
+	missing := filepath.Join(dir, "missing")
+	e := EstimateRecall(nil, []string{dir, missing}, map[string]bool{}, false)
+	if e.Files != 1 || e.Errors != 1 {
+		t.Fatalf("ls.EstimateRecall(%s, %s) = %d files, %d errors; want 1, 1", dir, missing, e.Files, e.Errors)
+	}
+}
+
+// Filesystems without stat data, like afero.MemMapFs, work for every command
+func TestMemMapFs(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	checkErr(afero.WriteFile(mem, "/proj/a", []byte("hello"), 0644))
+
+	if r := StatFile(mem, "/proj/a", false, false); r.Size != 5 || r.UID != uint32(os.Getuid()) || len(r.MountPoint) != 0 {
+		t.Fatalf("ls.StatFile(/proj/a) = %+v; want 5 bytes owned by %d and no mount", r, os.Getuid())
+	}
+	if e := EstimateRecall(mem, []string{"/proj"}, map[string]bool{}, false); e.Files != 1 || e.Errors != 0 {
+		t.Fatalf("ls.EstimateRecall(/proj) = %d files, %d errors; want 1, 0", e.Files, e.Errors)
+	}
+
+	fx, err := fake.Parse(strings.NewReader(`{"files": {"/proj/a": {}}}`))
+	checkErr(err)
+	defer func(backend storage.Backend) { storage.DefaultBackend = backend }(storage.DefaultBackend)
+	storage.DefaultBackend = fx.Backend()
+	l := New([]string{"/proj"})
+	l.SetFs(mem)
+	l.SetFlags(Flags{Color: map[string]bool{"/proj": true}, Why: true, Policy: &policy.Rules{MinSize: 100}, Format: FormatCSV, Columns: []string{"name", "why"}})
+	l.StatAll()
+	var out strings.Builder
+	l.printDelimited(&out)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "a,") || len(lines[1]) == 2 {
+		t.Fatalf("ls.List.printDelimited() with --why = %q; want a and why it is resident", out.String())
+	}
+}
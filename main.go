@@ -1,18 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime/pprof"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
-	"gls/columnize"
-	"gls/config"
-	"gls/ls"
+	"github.com/olcf/gls/cache"
+	"github.com/olcf/gls/columnize"
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/daemon"
+	"github.com/olcf/gls/exporter"
+	"github.com/olcf/gls/ls"
+	"github.com/olcf/gls/policy"
+	"github.com/olcf/gls/serve"
+	"github.com/olcf/gls/snapshot"
+	"github.com/olcf/gls/stats"
+	"github.com/olcf/gls/storage"
 
+	"github.com/mattn/go-isatty"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/spf13/afero"
 	// We use kingpin here to allow combining of short flags (e.g. -lha) and better handle positional arguments
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -24,6 +42,15 @@ func checkErr(err error) {
 	}
 }
 
+// Log to stderr like the listing does, for commands that don't go through ls.New and ls.SetFlags
+func setupLogging(debug bool) {
+	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+}
+
 // Returns output and return code
 func runCommand(cmd []string) ([]string, int) {
 	command := exec.Command(cmd[0], cmd[1:]...)
@@ -40,57 +67,196 @@ func runCommand(cmd []string) ([]string, int) {
 	return strings.Split(string(output), "\n"), exitCode
 }
 
-// Make a pretty output for our hints blurb
-func displayHints() {
-	columnize.New()
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.Blue,
-			0,
-			[]string{"Blue:", "Indicates a directory"}))
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.Green,
-			0,
-			[]string{"Green:", config.Ret0Hint}))
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.Yellow,
-			0,
-			[]string{"Yellow:", config.Ret1Hint}))
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.Red,
-			0,
-			[]string{"Red:", config.Ret2Hint}))
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.LightBlue,
-			0,
-			[]string{"Light Blue:", "Indicates a symbolic link"}))
-	columnize.PrintLine(
-		columnize.ColumnizeRow(
-			columnize.BlinkingRedBackground,
-			0,
-			[]string{"White on Red:", "Indicates a file resident on disk that will never be able to migrate to tape because it is too large"}))
-	columnize.Flush()
+// Load the site and user theme files on top of the configured palette
+func loadTheme() *columnize.Theme {
+	theme, ok := columnize.Palettes[config.Palette]
+	if !ok {
+		panic(fmt.Sprintf("unknown palette %q in config.Palette", config.Palette))
+	}
+	theme.Precedence = columnize.Precedence(config.StateColorPrecedence)
+	if !theme.Precedence.Valid() {
+		panic(fmt.Sprintf("unknown precedence %q in config.StateColorPrecedence", config.StateColorPrecedence))
+	}
+	files := []string{config.SiteThemeFile}
+	if path := os.Getenv("GLS_THEME"); len(path) != 0 {
+		files = append(files, path)
+	} else if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "gls", "theme"))
+	}
+	// A mistake in a theme file shouldn't keep anyone from listing their files
+	theme, err := columnize.LoadTheme(theme, files...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gls: ignoring part of the theme:", err)
+	}
+	return &theme
+}
+
+// Make a pretty output for our hints blurb. Shows the colors of the active palette/theme and,
+// when an indicator is in use, the text, symbol or suffix that goes with each state
+func displayHints(theme *columnize.Theme, colored bool, indicator string) {
+	hints := []struct {
+		color  columnize.Color
+		label  string
+		symbol string
+		hint   string
+	}{
+		{theme.Directory, "Directory", "", "Indicates a directory"},
+		{theme.Resident, config.Ret0Str, config.Ret0Symbol, config.Ret0Hint},
+		{theme.Premigrated, config.Ret1Str, config.Ret1Symbol, config.Ret1Hint},
+		{theme.Migrated, config.Ret2Str, config.Ret2Symbol, config.Ret2Hint},
+		{theme.DirResident, config.DirResidentStr, config.DirResidentSymbol, "With --dir-state, a directory whose files are all resident on disk"},
+		{theme.DirPremigrated, config.DirPremigratedStr, config.DirPremigratedSymbol, "With --dir-state, a directory whose files are all premigrated"},
+		{theme.DirMigrated, config.DirMigratedStr, config.DirMigratedSymbol, "With --dir-state, a directory whose files are all migrated to tape"},
+		{theme.DirMixed, config.DirMixedStr, config.DirMixedSymbol, "With --dir-state, a directory containing a mix of resident and migrated files"},
+		{"", "Trailing ?", "", "With --dir-state, only part of the directory was checked (see DirStateMaxDepth and DirStateTimeBudget)"},
+		{theme.Symlink, "Symbolic link", "", "Indicates a symbolic link. With -l the target is shown in the color of its storage state"},
+		{theme.Orphan, config.BrokenLinkStr, config.BrokenLinkSymbol, "Indicates a symbolic link whose target doesn't exist"},
+		{theme.TooLarge, config.TooLargeStr, config.TooLargeSymbol, "Indicates a file resident on disk that will never be able to migrate to tape because it is too large"},
+	}
+	table := columnize.NewTable(os.Stdout, colored, 2)
+	for _, h := range hints {
+		row := []string{columnize.Colorize(h.color, h.label)}
+		if indicator == ls.IndicatorSymbol || indicator == ls.IndicatorSuffix {
+			symbol := h.symbol
+			if indicator == ls.IndicatorSuffix {
+				symbol = ls.Suffix(symbol)
+			}
+			if len(symbol) == 0 {
+				symbol = " "
+			}
+			row = append(row, symbol)
+		}
+		table.PrintLine(append(row, h.hint))
+	}
+	checkErr(table.Flush())
+}
+
+// Should the listing be colored? auto only colors when stdout is a terminal, unless CLICOLOR_FORCE or NO_COLOR
+// (see https://no-color.org) say otherwise
+func useColor(when string) bool {
+	switch when {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if force := os.Getenv("CLICOLOR_FORCE"); len(force) != 0 && force != "0" {
+		return true
+	}
+	if len(os.Getenv("NO_COLOR")) != 0 {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
 }
 
 // This function is used to check if we should attempt colorizing the results.
 // I.E. files that aren't on GPFS aren't technically 'resident' or 'migrated' they just are
 func checkForColorize(paths []string) map[string]bool {
-	matches := config.GpfsRoots
-
 	ret := make(map[string]bool, len(paths))
 	for _, path := range paths {
-		ret[path] = false
-		for _, match := range matches {
-			if path == match || strings.Contains(path, match) {
-				ret[path] = true
+		ret[path] = storage.OnGPFS(path, config.GpfsRoots)
+	}
+	return ret
+}
+
+const defaultWatchInterval = 2 * time.Second
+
+// In-memory tree listed instead of the filesystem. Only ever set by the tests, see TestMain in main_test.go
+var fixtureFs afero.Fs
+
+// kingpin has no flags with optional values, so turn a bare --watch into --watch=defaultWatchInterval, or
+// --watch=INTERVAL when the next argument is a duration such as 5s. Use ./5s to list a file named like one
+func expandWatchFlag(args []string) []string {
+	var expanded []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+		if arg == "--watch" {
+			interval := defaultWatchInterval.String()
+			if i+1 < len(args) {
+				if _, err := time.ParseDuration(args[i+1]); err == nil {
+					i++
+					interval = args[i]
+				}
 			}
+			arg += "=" + interval
 		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// Commands other than listing are written with a colon, e.g. gls :stat FILE, so that bare arguments are always paths
+// and gls stays a drop-in ls. Turns a first argument naming a command like that into the command, and puts the list
+// command in front of everything else. List a path that starts with a colon as ./:name
+func commandArgs(args []string, isCommand func(name string) bool) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if name := strings.TrimPrefix(arg, ":"); name != arg && isCommand(name) {
+			rewritten := append([]string(nil), args...)
+			rewritten[i] = name
+			return rewritten
+		}
+		break
+	}
+	return append([]string{"list"}, args...)
+}
+
+// The site migration policy from config
+func sitePolicy() *policy.Rules {
+	rules := &policy.Rules{
+		MinSize:         config.PolicyMinSize,
+		ExcludePaths:    config.PolicyExcludePaths,
+		ExcludePatterns: config.PolicyExcludePatterns,
+		MinAge:          config.PolicyMinAge,
+		AgeBy:           config.PolicyAgeBy,
+	}
+	if !config.DisableSizeChecking {
+		// The same cutoff the TOO LARGE TO MIGRATE warning uses
+		rules.MaxSize = (config.MaxFileSizeGB+1)<<30 - 1
+	}
+	return rules
+}
+
+// A server on addr whose clients get timeout to send a request and twice that to read the answer, so a slow or
+// stalled client can't hold a connection forever
+func httpServer(addr string, handler http.Handler, timeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeout,
+		ReadTimeout:       timeout,
+		WriteTimeout:      2 * timeout,
+	}
+}
+
+// Absolute, cleaned versions of paths
+func absPaths(paths []string) []string {
+	var clean []string
+	for _, path := range paths {
+		p, err := filepath.Abs(path)
+		checkErr(err)
+		clean = append(clean, filepath.Clean(p))
+	}
+	return clean
+}
+
+// Storage state of files for snapshots, sharing one limiter. Files outside config.GpfsRoots have none
+func fileStates() snapshot.StateFunc {
+	state := ls.FileStates()
+	return func(path string) storage.State {
+		if !checkForColorize([]string{path})[path] {
+			return storage.Unknown
+		}
+		return state(path)
 	}
-	return ret
 }
 
 func main() {
@@ -104,14 +270,63 @@ func main() {
 		}()
 	}
 
-	long := kingpin.Flag("long", "Long listing").Short('l').Bool()
-	human := kingpin.Flag("human", "Human readable listing").Short('h').Bool()
-	all := kingpin.Flag("all", "Show all files including hidden files").Short('a').Bool()
 	disable := kingpin.Flag("disable-wrapper", "Disable wrapper and fall back to standard ls").Bool()
 	hints := kingpin.Flag("hints", "Display hints about color code meanings").Short('H').Bool()
-	time := kingpin.Flag("time", "Sort output by time last modified").Short('t').Bool()
-	noColor := kingpin.Flag("no-color", "Disable coloring and use text for storage pool location").Short('n').Bool()
-	paths := kingpin.Arg("paths", "Paths to list").Default(".").Strings()
+
+	// Listing needs no command so `gls -l <paths>` keeps working like ls, see commandArgs
+	listCmd := kingpin.Command("list", "List files and their storage state. This is what gls does unless the first argument is a command with a colon in front, e.g. gls :stat FILE (see gls :help)")
+	long := listCmd.Flag("long", "Long listing").Short('l').Bool()
+	human := listCmd.Flag("human", "Human readable listing").Short('h').Bool()
+	all := listCmd.Flag("all", "Show all files including hidden files").Short('a').Bool()
+	time := listCmd.Flag("time", "Sort output by time last modified").Short('t').Bool()
+	noColor := listCmd.Flag("no-color", "Disable coloring and use text for storage pool location").Short('n').Bool()
+	colorWhen := listCmd.Flag("color", "When to color the output: auto (only on a terminal), always or never").Default("auto").Enum("auto", "always", "never")
+	indicator := listCmd.Flag("indicator", "How to show the storage state next to names: none, text, symbol (own column) or suffix (like ls -F). Defaults to text with -n, otherwise none").Enum(ls.IndicatorNone, ls.IndicatorText, ls.IndicatorSymbol, ls.IndicatorSuffix)
+	pools := listCmd.Flag("pools", "Show the storage pool and tape copies of each file in long listings").Bool()
+	dirState := listCmd.Flag("dir-state", "Color directories by the storage state of the files inside them").Bool()
+	directory := listCmd.Flag("directory", "List directories themselves, not their contents").Short('d').Bool()
+	dereference := listCmd.Flag("dereference", "Show information and storage state for the file a symbolic link references").Short('L').Bool()
+	formatTemplate := listCmd.Flag("format-template", "Print each file with a Go text/template, or the name of a template from config.FormatTemplates").PlaceHolder("TMPL").String()
+	format := listCmd.Flag("format", "Output format: text, csv or tsv").Default(ls.FormatText).Enum(ls.FormatText, ls.FormatCSV, ls.FormatTSV)
+	columns := listCmd.Flag("columns", "Comma separated columns for --format=csv and tsv: "+strings.Join(ls.Columns(), ", ")).Default(strings.Join(config.DefaultColumns, ",")).String()
+	watch := listCmd.Flag("watch", "Refresh the listing every INTERVAL (default "+defaultWatchInterval.String()+"), highlighting files whose storage state changed").PlaceHolder("INTERVAL").Duration()
+	until := listCmd.Flag("until", "With --watch, exit once every file is on disk (resident or premigrated)").Enum("resident")
+	cacheMode := listCmd.Flag("cache", "Per-user cache of storage states: off, read (use unchanged entries younger than --max-age) or refresh (look every file up and store it)").Default(config.CacheMode).Enum(cache.Off, cache.Read, cache.Refresh)
+	maxAge := listCmd.Flag("max-age", "With --cache=read, look files up again once their cached state is older than this").Default(config.CacheMaxAge.String()).Duration()
+	showStats := listCmd.Flag("stats", "Report on stderr where the time went: directory reads, lstat, owner lookups, attribute checks and rendering").Bool()
+	statsFormat := listCmd.Flag("stats-format", "Format of the --stats report: text or json").Default("text").Enum("text", "json")
+	why := listCmd.Flag("why", "Explain why resident files haven't been migrated according to the site policy").Bool()
+	paths := listCmd.Arg("paths", "Paths to list").Default(".").Strings()
+
+	statCmd := kingpin.Command("stat", "Display everything gls knows about a single file")
+	statJSON := statCmd.Flag("json", "Output as JSON").Bool()
+	statPath := statCmd.Arg("path", "File to inspect").Required().String()
+
+	serveCmd := kingpin.Command("serve", "Answer storage state queries over HTTP with JSON (see package serve)")
+	serveListen := serveCmd.Flag("listen", "Address to listen on").Default(config.ServeListen).String()
+	serveRoots := serveCmd.Flag("root", "Only answer for paths below this one; repeat for several (default config.ServeRoots, or config.GpfsRoots)").Strings()
+	serveTimeout := serveCmd.Flag("timeout", "Longest a request may take").Default(config.ServeTimeout.String()).Duration()
+	serveMaxRequests := serveCmd.Flag("max-requests", "Requests worked on at once; more are turned away").Default(strconv.Itoa(config.ServeMaxRequests)).Int()
+
+	exporterCmd := kingpin.Command("exporter", "Publish the files and bytes in each storage state per directory and owner as Prometheus metrics")
+	exporterListen := exporterCmd.Flag("listen", "Address to serve /metrics on").Default(config.ExporterListen).String()
+	exporterTextfile := exporterCmd.Flag("textfile", "Scan once and write the metrics to this .prom file for node_exporter's textfile collector instead").PlaceHolder("FILE").String()
+	exporterInterval := exporterCmd.Flag("interval", "Time between the starts of two scans").Default(config.ExporterInterval.String()).Duration()
+	exporterDirs := exporterCmd.Flag("dir", "Directory to report on; repeat for several (default config.ExporterDirs, or config.GpfsRoots)").Strings()
+
+	snapshotCmd := kingpin.Command("snapshot", "Record the size, mtime and storage state of every file below paths")
+	snapshotOut := snapshotCmd.Flag("output", "File to write the snapshot to").Short('o').Required().String()
+	snapshotPaths := snapshotCmd.Arg("paths", "Paths to record").Default(".").Strings()
+
+	diffCmd := kingpin.Command("diff", "Show files that changed state, appeared, disappeared or changed size since a snapshot")
+	diffJSON := diffCmd.Flag("json", "Output as JSON").Bool()
+	diffOld := diffCmd.Arg("old", "Snapshot to compare against").Required().String()
+	diffNew := diffCmd.Arg("new", "A newer snapshot, or paths to look at now. Defaults to the paths in the old snapshot").Strings()
+
+	estimateCmd := kingpin.Command("estimate", "Estimate how long recalling the migrated files below paths would take")
+	estimateJSON := estimateCmd.Flag("json", "Output as JSON").Bool()
+	estimatePaths := estimateCmd.Arg("paths", "Paths to estimate").Default(".").Strings()
+
 	var cpuprofPath *string
 	var debug *bool
 
@@ -123,7 +338,9 @@ func main() {
 		debug = kingpin.Flag("debug", "Display debug information").Short('v').Bool()
 	}
 
-	kingpin.Parse()
+	// kingpin only adds its help command while parsing
+	isCommand := func(name string) bool { return name == "help" || kingpin.CommandLine.GetCommand(name) != nil }
+	command := kingpin.MustParse(kingpin.CommandLine.Parse(commandArgs(expandWatchFlag(os.Args[1:]), isCommand)))
 
 	if len(*cpuprofPath) != 0 {
 		cpuprofile := *cpuprofPath
@@ -138,8 +355,30 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	fs := fixtureFs
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	if fixtureFs == nil && len(config.DaemonSocket) != 0 {
+		// Without glsd every file is looked up directly, as before
+		if client, err := daemon.Dial(config.DaemonSocket, storage.DefaultBackend); err == nil {
+			defer client.Close()
+			storage.DefaultBackend = client
+		}
+	}
+
+	// -n has always meant text annotations. Colors turned off by --color or a pipe leave names bare, like ls
+	if len(*indicator) == 0 {
+		*indicator = ls.IndicatorNone
+		if *noColor {
+			*indicator = ls.IndicatorText
+		}
+	}
+	colored := !*noColor && useColor(*colorWhen)
+
+	theme := loadTheme()
 	if *hints {
-		displayHints()
+		displayHints(theme, colored, *indicator)
 		os.Exit(0)
 	}
 
@@ -159,26 +398,230 @@ func main() {
 		os.Exit(err)
 	}
 
-	var cleanPaths []string
-	//Get the absolute paths, and clean them (in case of symlinks)
-	for _, path := range *paths {
-		p, err := filepath.Abs(path)
+	switch command {
+	case statCmd.FullCommand():
+		p, err := filepath.Abs(*statPath)
+		checkErr(err)
+		p = filepath.Clean(p)
+		report := ls.StatFile(fs, p, checkForColorize([]string{p})[p], *debug)
+		if *statJSON {
+			report.PrintJSON(os.Stdout)
+		} else {
+			report.PrintText(os.Stdout)
+		}
+		return
+	case serveCmd.FullCommand():
+		roots := *serveRoots
+		if len(roots) == 0 {
+			roots = config.ServeRoots
+		}
+		if len(roots) == 0 {
+			roots = config.GpfsRoots
+		}
+		setupLogging(*debug)
+		server := serve.New(storage.NewClient(), absPaths(roots), *serveTimeout, *serveMaxRequests)
+		fmt.Fprintf(os.Stderr, "Answering for %s on http://%s\n", strings.Join(server.Roots, ", "), *serveListen)
+		checkErr(httpServer(*serveListen, server, *serveTimeout).ListenAndServe())
+		return
+	case exporterCmd.FullCommand():
+		setupLogging(*debug)
+		dirs := *exporterDirs
+		if len(dirs) == 0 {
+			dirs = config.ExporterDirs
+		}
+		if len(dirs) == 0 {
+			dirs = config.GpfsRoots
+		}
+		e := exporter.New(storage.NewClient(), absPaths(dirs))
+		if len(*exporterTextfile) != 0 {
+			e.Scan(context.Background())
+			checkErr(e.WriteFile(*exporterTextfile))
+			return
+		}
+		go e.Run(context.Background(), *exporterInterval)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", e)
+		fmt.Fprintf(os.Stderr, "Reporting on %s at http://%s/metrics\n", strings.Join(e.Dirs, ", "), *exporterListen)
+		checkErr(httpServer(*exporterListen, mux, config.ExporterTimeout).ListenAndServe())
+		return
+	case snapshotCmd.FullCommand():
+		setupLogging(*debug)
+		snap, err := snapshot.Take(fs, absPaths(*snapshotPaths), fileStates())
+		checkErr(err)
+		checkErr(snap.Save(*snapshotOut))
+		return
+	case estimateCmd.FullCommand():
+		roots := absPaths(*estimatePaths)
+		estimate := ls.EstimateRecall(fs, roots, checkForColorize(roots), *debug)
+		if *estimateJSON {
+			estimate.PrintJSON(os.Stdout)
+		} else {
+			estimate.PrintText(os.Stdout)
+		}
+		return
+	case diffCmd.FullCommand():
+		setupLogging(*debug)
+		old, err := snapshot.Load(*diffOld)
 		checkErr(err)
-		cleanPaths = append(cleanPaths, filepath.Clean(p))
+		var current *snapshot.Snapshot
+		if len(*diffNew) == 1 {
+			// A second snapshot file rather than a directory to look at
+			if info, err := fs.Stat((*diffNew)[0]); err != nil || !info.IsDir() {
+				current, err = snapshot.Load((*diffNew)[0])
+				checkErr(err)
+			}
+		}
+		if current == nil {
+			roots := old.Roots
+			if len(*diffNew) > 0 {
+				roots = absPaths(*diffNew)
+			}
+			current, err = snapshot.Take(fs, roots, fileStates())
+			checkErr(err)
+		}
+		changes := snapshot.Diff(old, current)
+		if *diffJSON {
+			checkErr(snapshot.PrintJSON(os.Stdout, changes))
+		} else {
+			checkErr(snapshot.PrintText(os.Stdout, changes))
+		}
+		return
+	}
+
+	//Get the absolute paths, and clean them (in case of symlinks)
+	cleanPaths := absPaths(*paths)
+
+	var lsColors *columnize.LSColors
+	if env := os.Getenv("LS_COLORS"); config.UseLSColors && len(env) != 0 {
+		lsColors = columnize.ParseLSColors(env)
+	}
+
+	var tmpl *template.Template
+	if len(*formatTemplate) != 0 {
+		text, ok := config.FormatTemplates[*formatTemplate]
+		if !ok {
+			text = *formatTemplate
+		}
+		var err error
+		tmpl, err = ls.NewTemplate(text)
+		// Catch unknown fields before statting anything
+		if err == nil {
+			err = tmpl.Execute(io.Discard, ls.Entry{})
+		}
+		if err != nil {
+			kingpin.Fatalf("invalid --format-template: %s", err)
+		}
+		// Reading the pool and tape copies costs an extra call per file, so only do it when the template uses them
+		if strings.Contains(text, ".Pool") || strings.Contains(text, ".TapeIDs") {
+			*pools = true
+		}
+	}
+
+	cols := strings.Split(*columns, ",")
+	if err := ls.CheckColumns(cols); err != nil {
+		kingpin.Fatalf("invalid --columns: %s", err)
+	}
+	if *format != ls.FormatText {
+		for _, c := range cols {
+			if c == "pool" || c == "tapes" {
+				*pools = true
+			}
+		}
+	}
+
+	var ilm *policy.ILMPolicy
+	if *why && len(config.PolicyFile) != 0 {
+		var err error
+		if ilm, err = policy.LoadILM(config.PolicyFile); err != nil {
+			kingpin.Fatalf("reading the policy file: %s", err)
+		}
+		ilm.Filesets = config.PolicyFilesets
+	}
+
+	var states *cache.Cache
+	if *cacheMode != cache.Off {
+		mode := *cacheMode
+		// Watching is about seeing states change, which cached ones can't
+		if *watch > 0 || len(*until) != 0 {
+			mode = cache.Refresh
+		}
+		if path, err := cache.DefaultPath(); err == nil {
+			states = cache.Open(path, mode, *maxAge, config.CacheEntries)
+		}
+	}
+
+	var recorder *stats.Recorder
+	if *showStats {
+		recorder = stats.New()
 	}
 
 	listFlags := ls.Flags{
-		Long:       *long,
-		Human:      *human,
-		All:        *all,
-		Color:      checkForColorize(cleanPaths),
-		SortByTime: *time,
-		NoColor:    *noColor,
-		Debug:      *debug,
+		Long:        *long,
+		Human:       *human,
+		All:         *all,
+		Color:       checkForColorize(cleanPaths),
+		SortByTime:  *time,
+		NoColor:     !colored,
+		Indicator:   *indicator,
+		Debug:       *debug,
+		Pools:       *pools,
+		DirState:    *dirState,
+		Directory:   *directory,
+		Dereference: *dereference,
+		Theme:       theme,
+		LSColors:    lsColors,
+		Template:    tmpl,
+		Format:      *format,
+		Columns:     cols,
+		Why:         *why,
+		Policy:      sitePolicy(),
+		ILM:         ilm,
+		Cache:       states,
+		Stats:       recorder,
 	}
 
 	list := ls.New(cleanPaths)
 	list.SetFlags(listFlags)
+	list.SetFs(fs)
+	if *watch > 0 || len(*until) != 0 {
+		interval := *watch
+		if interval <= 0 {
+			interval = defaultWatchInterval
+		}
+		list.Watch(os.Stdout, ls.WatchOptions{
+			Interval:      interval,
+			Redraw:        isatty.IsTerminal(os.Stdout.Fd()),
+			UntilResident: *until == "resident",
+			// Watching usually ends with Ctrl-C, so the report covers every refresh so far
+			Refreshed: func() {
+				saveCache(states)
+				printStats(recorder, *statsFormat)
+			},
+		})
+		return
+	}
 	list.StatAll()
-	list.Print()
+	list.Print(os.Stdout)
+	saveCache(states)
+	printStats(recorder, *statsFormat)
+}
+
+// Write the --stats report to stderr, out of the way of the listing
+func printStats(r *stats.Recorder, format string) {
+	if r == nil {
+		return
+	}
+	report := r.Report()
+	if format == "json" {
+		checkErr(report.PrintJSON(os.Stderr))
+	} else {
+		checkErr(report.PrintText(os.Stderr))
+	}
+}
+
+// The listing is already out, so a cache that can't be written only costs the next run some lookups
+func saveCache(c *cache.Cache) {
+	if err := c.Save(); err != nil {
+		fmt.Fprintln(os.Stderr, "gls: unable to save the cache:", err)
+	}
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/fake"
+	"github.com/olcf/gls/storage"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata/golden with the current output")
+
+// The golden tests run gls by running the test binary again with GLS_TEST_MAIN set, so main() parses the
+// arguments and prints exactly like the real thing. GLS_TEST_FIXTURE names a fake.Fixture file to list, with the
+// storage states it gives, instead of the filesystem
+func TestMain(m *testing.M) {
+	if os.Getenv("GLS_TEST_MAIN") == "1" {
+		if path := os.Getenv("GLS_TEST_FIXTURE"); len(path) != 0 {
+			fx, err := fake.Load(path)
+			checkErr(err)
+			fixtureFs, err = fx.Fs()
+			checkErr(err)
+			storage.DefaultBackend = fx.Backend()
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Run gls with args against testdata/fixture.json and return what it printed
+func runGls(t *testing.T, args ...string) string {
+	return runGlsHome(t, t.TempDir(), args...)
+}
+
+// runGls with home as the home, config and cache directory, so runs can share a cache
+func runGlsHome(t *testing.T, home string, args ...string) string {
+	out, _ := runGlsOutputs(t, home, args...)
+	return out
+}
+
+// runGlsHome that also returns what gls printed on stderr
+func runGlsOutputs(t *testing.T, home string, args ...string) (string, string) {
+	cmd := exec.Command(os.Args[0], args...)
+	// Later entries win, so none of the user's settings leak in
+	cmd.Env = append(os.Environ(),
+		"GLS_TEST_MAIN=1",
+		"GLS_TEST_FIXTURE="+filepath.Join("testdata", "fixture.json"),
+		"TZ=UTC",
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+home,
+		"XDG_CACHE_HOME="+home,
+		"GLS_THEME=",
+		"LS_COLORS=",
+		"NO_COLOR=",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("gls %s: %s\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return string(out), stderr.String()
+}
+
+var (
+	durationPattern = regexp.MustCompile(`[0-9.]+(ns|µs|ms|s)\b`)
+	workersPattern  = regexp.MustCompile(`(at most|limit) [0-9]+`)
+)
+
+// The --stats report with the timings and the limiter's decisions, which change from run to run, blanked out
+func normalizeStats(stats string) string {
+	stats = durationPattern.ReplaceAllString(stats, "<duration>")
+	return workersPattern.ReplaceAllString(stats, "$1 <n>")
+}
+
+func TestGolden(t *testing.T) {
+	const proj = "/gpfs/themis/proj"
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"default", []string{proj}},
+		{"color", []string{"--color=always", proj}},
+		{"no-color", []string{"-n", proj}},
+		{"long", []string{"-l", proj}},
+		{"long-color", []string{"-l", "--color=always", proj}},
+		{"long-no-color", []string{"-ln", proj}},
+		{"all", []string{"-lan", proj}},
+		{"human", []string{"-lhn", proj}},
+		{"time", []string{"-ltn", proj}},
+		{"directory", []string{"-ldn", proj, proj + "/archive"}},
+		{"dereference", []string{"-lLn", proj}},
+		{"pools", []string{"-ln", "--pools", proj}},
+		{"dir-state", []string{"-ln", "--dir-state", proj}},
+		{"dir-state-color", []string{"-l", "--dir-state", "--color=always", proj}},
+		{"indicator-symbol", []string{"-l", "--indicator=symbol", proj}},
+		{"indicator-suffix", []string{"--indicator=suffix", proj}},
+		{"several-paths", []string{"-n", proj + "/archive", proj + "/mixed"}},
+		{"csv", []string{"--format=csv", "--columns=" + strings.Join(columnsUnderTest, ","), proj}},
+		{"tsv", []string{"--format=tsv", proj}},
+		{"template", []string{"--format-template=tape", proj}},
+		{"template-custom", []string{"--format-template={{.Name}}\t{{.State}}\t{{human .Size}}", proj}},
+		{"why", []string{"-n", "--why", proj}},
+		{"hints", []string{"-H", "-n", "--indicator=symbol"}},
+		{"stats", []string{"-n", "--stats", proj}},
+		{"stat", []string{":stat", proj + "/data.h5"}},
+		{"stat-json", []string{":stat", "--json", proj + "/latest"}},
+		{"estimate", []string{":estimate", proj}},
+		{"estimate-json", []string{":estimate", "--json", proj}},
+		{"diff", []string{":diff", filepath.Join("testdata", "old.snap")}},
+		{"diff-json", []string{":diff", "--json", filepath.Join("testdata", "old.snap")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			have, stderr := runGlsOutputs(t, t.TempDir(), test.args...)
+			// The --stats report goes to stderr, after the listing
+			for _, arg := range test.args {
+				if arg == "--stats" {
+					have += normalizeStats(stderr)
+				}
+			}
+			golden := filepath.Join("testdata", "golden", test.name+".txt")
+			if *update {
+				if err := os.WriteFile(golden, []byte(have), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+			if have != string(want) {
+				t.Fatalf("gls %s = \n%s\nwant (%s)\n%s", strings.Join(test.args, " "), have, golden, want)
+			}
+		})
+	}
+}
+
+// Every column except mtime, which the long listings already cover
+var columnsUnderTest = []string{"name", "path", "type", "size", "mode", "owner", "group", "state", "pool", "tapes", "target", "broken", "too_large", "why", "cached"}
+
+func TestCache(t *testing.T) {
+	const proj = "/gpfs/themis/proj"
+	home := t.TempDir()
+	tests := []struct {
+		args []string
+		// Whether data.h5 is marked as cached
+		cached bool
+	}{
+		{[]string{"--cache=off"}, false},
+		// Nothing cached yet
+		{[]string{"--cache=read"}, false},
+		{[]string{"--cache=read"}, true},
+		{[]string{"--cache=read", "--max-age=0s"}, false},
+		{[]string{"--cache=refresh"}, false},
+		{[]string{"--cache=read"}, true},
+	}
+	for _, test := range tests {
+		out := runGlsHome(t, home, append([]string{"-n", proj}, test.args...)...)
+		if have := strings.Contains(out, "data.h5 ("+config.CachedStr+")"); have != test.cached {
+			t.Fatalf("gls %s marks data.h5 as cached = %v; want %v\n%s", strings.Join(test.args, " "), have, test.cached, out)
+		}
+		// The state itself comes out the same either way
+		if !strings.Contains(out, "("+config.Ret2Str+") data.h5") {
+			t.Fatalf("gls %s = \n%s\nwant data.h5 %s", strings.Join(test.args, " "), out, config.Ret2Str)
+		}
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	isCommand := func(name string) bool { return name == "stat" || name == "list" }
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"list"}},
+		{[]string{"-l", "stat"}, []string{"list", "-l", "stat"}},
+		{[]string{":stat", "file"}, []string{"stat", "file"}},
+		{[]string{"--debug", ":stat", "file"}, []string{"--debug", "stat", "file"}},
+		{[]string{":list", "stat"}, []string{"list", "stat"}},
+		// Only the first argument can be a command, and only a known one
+		{[]string{"dir", ":stat"}, []string{"list", "dir", ":stat"}},
+		{[]string{":other"}, []string{"list", ":other"}},
+		{[]string{"--", ":stat"}, []string{"list", "--", ":stat"}},
+	}
+	for _, test := range tests {
+		if have := commandArgs(test.args, isCommand); !reflect.DeepEqual(have, test.want) {
+			t.Fatalf("commandArgs(%v) = %v; want %v", test.args, have, test.want)
+		}
+	}
+}
+
+func TestExpandWatchFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--watch", "dir"}, []string{"--watch=2s", "dir"}},
+		{[]string{"--watch", "5s", "dir"}, []string{"--watch=5s", "dir"}},
+		{[]string{"--watch=1m", "5s"}, []string{"--watch=1m", "5s"}},
+		{[]string{"-l", "--watch"}, []string{"-l", "--watch=2s"}},
+		{[]string{"--", "--watch", "5s"}, []string{"--", "--watch", "5s"}},
+	}
+	for _, test := range tests {
+		if have := expandWatchFlag(test.args); !reflect.DeepEqual(have, test.want) {
+			t.Fatalf("expandWatchFlag(%v) = %v; want %v", test.args, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,947 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The subset of the GPFS ILM policy language (the rule files given to mmapplypolicy) that gls understands:
+//
+//	RULE ['name'] MIGRATE [FROM POOL 'pool'] [THRESHOLD(high[,low[,premig]])] [WEIGHT(expr)]
+//	    TO POOL 'pool' [LIMIT(n)] [REPLICATE(n)] [FOR FILESET('name'[,...])] [WHERE expr]
+//	RULE ['name'] EXCLUDE [DIRECTORIES_PLUS] [FOR FILESET('name'[,...])] [WHERE expr]
+//
+// Other rules (EXTERNAL POOL, SET POOL, LIST, DELETE, ...) are skipped. WHERE clauses may use AND, OR, NOT,
+// comparisons, [NOT] LIKE, [NOT] IN (...), + - * /, CURRENT_TIMESTAMP, INTERVAL 'n' DAYS (or n DAYS),
+// the functions DAYS, LOWER, UPPER and LENGTH and the attributes listed in attributes below
+type ILMPolicy struct {
+	Rules []ILMRule
+	// Fileset junction paths to fileset names, to tell which fileset a file is in when File.Fileset isn't set
+	Filesets map[string]string
+}
+
+type ILMRule struct {
+	Name string
+	// "MIGRATE" or "EXCLUDE"
+	Action   string
+	FromPool string
+	ToPool   string
+	// High occupancy percentage of FromPool that triggers the migration, 0 if the rule has no THRESHOLD
+	Threshold float64
+	Filesets  []string
+	// nil when the rule has no WHERE clause
+	Where expr
+}
+
+// Attributes available in WHERE clauses
+var attributes = map[string]func(f File) value{
+	"FILE_SIZE":         func(f File) value { return number(float64(f.Size)) },
+	"KB_ALLOCATED":      func(f File) value { return number(float64(f.KBAllocated)) },
+	"ACCESS_TIME":       func(f File) value { return timestamp(f.Atime) },
+	"MODIFICATION_TIME": func(f File) value { return timestamp(f.Mtime) },
+	"CHANGE_TIME":       func(f File) value { return timestamp(f.Ctime) },
+	"USER_ID":           func(f File) value { return number(float64(f.UID)) },
+	"GROUP_ID":          func(f File) value { return number(float64(f.GID)) },
+	"NAME":              func(f File) value { return str(f.Path[strings.LastIndex(f.Path, "/")+1:]) },
+	"PATH_NAME":         func(f File) value { return str(f.Path) },
+	"POOL_NAME":         func(f File) value { return str(f.Pool) },
+}
+
+// Read and parse the rule file at path
+func LoadILM(path string) (*ILMPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseILM(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse the rules in src
+func ParseILM(src string) (*ILMPolicy, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	policy := &ILMPolicy{}
+	for !p.at(tokEOF) {
+		if p.accept(";") {
+			continue
+		}
+		rule, ok, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			policy.Rules = append(policy.Rules, rule)
+		}
+	}
+	return policy, nil
+}
+
+// The first MIGRATE or EXCLUDE rule that applies to f at the time now, like mmapplypolicy picks it.
+// Rules limited to filesets don't match files in an unknown fileset, and FROM POOL is only checked when f.Pool is known
+func (p *ILMPolicy) Match(f File, now time.Time) (*ILMRule, error) {
+	r, _, err := p.match(f, now)
+	return r, err
+}
+
+// Match, plus the fileset rules skipped before it because f.Fileset is unknown
+func (p *ILMPolicy) match(f File, now time.Time) (*ILMRule, []*ILMRule, error) {
+	var skipped []*ILMRule
+	if f.Fileset == "" {
+		f.Fileset = FilesetOf(f.Path, p.Filesets)
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.FromPool != "" && f.Pool != "" && !strings.EqualFold(r.FromPool, f.Pool) {
+			continue
+		}
+		if len(r.Filesets) > 0 {
+			if f.Fileset == "" {
+				skipped = append(skipped, r)
+				continue
+			}
+			if !r.inFileset(f.Fileset) {
+				continue
+			}
+		}
+		if r.Where == nil {
+			return r, skipped, nil
+		}
+		v, err := r.Where.eval(f, now)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rule %s: %w", r.label(), err)
+		}
+		if v.truthy() {
+			return r, skipped, nil
+		}
+	}
+	return nil, skipped, nil
+}
+
+// What the next policy run will do with f
+func (p *ILMPolicy) Check(f File, now time.Time) (Verdict, error) {
+	r, skipped, err := p.match(f, now)
+	if err != nil {
+		return Verdict{}, err
+	}
+	v := p.verdict(r)
+	if len(skipped) > 0 {
+		var labels []string
+		for _, s := range skipped {
+			labels = append(labels, s.label())
+		}
+		v.Reason += "; skipped rule " + strings.Join(labels, ", ") + " for an unknown fileset"
+	}
+	return v, nil
+}
+
+func (p *ILMPolicy) verdict(r *ILMRule) Verdict {
+	switch {
+	case r == nil:
+		return Verdict{Reason: "no migration rule matches"}
+	case r.Action == "EXCLUDE":
+		return Verdict{Reason: "excluded by rule " + r.label(), Rule: r.label()}
+	case r.Threshold > 0:
+		return Verdict{Eligible: true, Reason: fmt.Sprintf("will migrate to pool %s once pool %s is %g%% full (rule %s)", r.ToPool, r.fromPool(), r.Threshold, r.label()), Rule: r.label()}
+	}
+	return Verdict{Eligible: true, Reason: fmt.Sprintf("will migrate to pool %s at next policy run (rule %s)", r.ToPool, r.label()), Rule: r.label()}
+}
+
+func (r *ILMRule) label() string {
+	if r.Name == "" {
+		return "(unnamed)"
+	}
+	return "'" + r.Name + "'"
+}
+
+// Whether the rule's FOR FILESET clause names fileset
+func (r *ILMRule) inFileset(fileset string) bool {
+	for _, name := range r.Filesets {
+		if name == fileset {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ILMRule) fromPool() string {
+	if r.FromPool == "" {
+		return "(any)"
+	}
+	return r.FromPool
+}
+
+// Lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	line int
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src); j++ {
+				if src[j] == c {
+					// A doubled quote is an escaped quote
+					if j+1 < len(src) && src[j+1] == c {
+						b.WriteByte(c)
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, token{tokString, b.String(), line})
+			i = j + 1
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], line})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, strings.ToUpper(src[i:j]), line})
+			i = j
+		default:
+			op := string(c)
+			for _, two := range []string{"<>", "!=", "<=", ">=", "||"} {
+				if strings.HasPrefix(src[i:], two) {
+					op = two
+				}
+			}
+			if !strings.Contains("()=<>!+-*/,;|", string(c)) {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+			toks = append(toks, token{tokOp, op, line})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", line}), nil
+}
+
+// Parser
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) at(kind tokKind) bool {
+	return p.peek().kind == kind
+}
+
+// Whether the next token starts a new rule or ends the file
+func (p *parser) atRuleEnd() bool {
+	t := p.peek()
+	return t.kind == tokEOF || t.kind == tokIdent && t.text == "RULE"
+}
+
+// Consume the next token if it is the keyword or operator text
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokIdent || t.kind == tokOp) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	near := t.text
+	if t.kind == tokEOF {
+		near = "end of file"
+	}
+	return fmt.Errorf("line %d near %q: %s", t.line, near, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) string() (string, error) {
+	if !p.at(tokString) {
+		return "", p.errorf("expected a quoted string")
+	}
+	return p.next().text, nil
+}
+
+// Parse one rule. ok is false for rules that are skipped
+func (p *parser) rule() (rule ILMRule, ok bool, err error) {
+	if err := p.expect("RULE"); err != nil {
+		return rule, false, err
+	}
+	if p.at(tokString) {
+		rule.Name = p.next().text
+	}
+	switch {
+	case p.accept("MIGRATE"):
+		rule.Action = "MIGRATE"
+	case p.accept("EXCLUDE"):
+		rule.Action = "EXCLUDE"
+		p.accept("DIRECTORIES_PLUS")
+	default:
+		// Anything else doesn't decide whether a file goes to tape
+		for !p.atRuleEnd() && !p.accept(";") {
+			p.next()
+		}
+		return rule, false, nil
+	}
+	for {
+		switch {
+		case p.accept("FROM"):
+			if err := p.expect("POOL"); err != nil {
+				return rule, false, err
+			}
+			if rule.FromPool, err = p.string(); err != nil {
+				return rule, false, err
+			}
+		case p.accept("TO"):
+			if err := p.expect("POOL"); err != nil {
+				return rule, false, err
+			}
+			if rule.ToPool, err = p.string(); err != nil {
+				return rule, false, err
+			}
+		case p.accept("THRESHOLD"):
+			args, err := p.numberList()
+			if err != nil {
+				return rule, false, err
+			}
+			rule.Threshold = args[0]
+		case p.accept("LIMIT"), p.accept("REPLICATE"):
+			if _, err := p.numberList(); err != nil {
+				return rule, false, err
+			}
+		case p.accept("WEIGHT"):
+			// Only affects the order files are migrated in
+			if err := p.expect("("); err != nil {
+				return rule, false, err
+			}
+			if _, err := p.expr(); err != nil {
+				return rule, false, err
+			}
+			if err := p.expect(")"); err != nil {
+				return rule, false, err
+			}
+		case p.accept("FOR"):
+			if err := p.expect("FILESET"); err != nil {
+				return rule, false, err
+			}
+			if err := p.expect("("); err != nil {
+				return rule, false, err
+			}
+			for {
+				name, err := p.string()
+				if err != nil {
+					return rule, false, err
+				}
+				rule.Filesets = append(rule.Filesets, name)
+				if !p.accept(",") {
+					break
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return rule, false, err
+			}
+		case p.accept("WHERE"):
+			if rule.Where, err = p.expr(); err != nil {
+				return rule, false, err
+			}
+		default:
+			if rule.Action == "MIGRATE" && rule.ToPool == "" {
+				return rule, false, p.errorf("MIGRATE rule without TO POOL")
+			}
+			if !p.atRuleEnd() && !p.accept(";") {
+				return rule, false, p.errorf("unsupported clause")
+			}
+			return rule, true, nil
+		}
+	}
+}
+
+func (p *parser) numberList() ([]float64, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var nums []float64
+	for {
+		if !p.at(tokNumber) {
+			return nil, p.errorf("expected a number")
+		}
+		n, err := strconv.ParseFloat(p.next().text, 64)
+		if err != nil {
+			return nil, err
+		}
+		nums = append(nums, n)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return nums, p.expect(")")
+}
+
+func (p *parser) expr() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{"OR", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{"AND", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("NOT") {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &not{e}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"=", "<>", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			return &binary{op, left, right}, nil
+		}
+	}
+	negate := p.accept("NOT")
+	var e expr
+	switch {
+	case p.accept("LIKE"):
+		pattern, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		e = &like{left, likeToRegexp(pattern)}
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		list := &inList{left: left}
+		for {
+			item, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			list.list = append(list.list, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		e = list
+	default:
+		if negate {
+			return nil, p.errorf("expected LIKE or IN after NOT")
+		}
+		return left, nil
+	}
+	if negate {
+		e = &not{e}
+	}
+	return e, nil
+}
+
+func (p *parser) sum() (expr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokOp || (op != "+" && op != "-" && op != "||") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op, left, right}
+	}
+}
+
+func (p *parser) product() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokOp || (op != "*" && op != "/") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{op, left, right}
+	}
+}
+
+// Units that turn a number into an interval, as in "90 DAYS"
+var intervalUnits = map[string]time.Duration{
+	"DAYS":    24 * time.Hour,
+	"DAY":     24 * time.Hour,
+	"HOURS":   time.Hour,
+	"HOUR":    time.Hour,
+	"MINUTES": time.Minute,
+	"MINUTE":  time.Minute,
+	"SECONDS": time.Second,
+	"SECOND":  time.Second,
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &binary{"-", literal{number(0)}, e}, nil
+	}
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if unit, ok := intervalUnits[p.peek().text]; ok && p.peek().kind == tokIdent {
+		p.next()
+		return &binary{"*", e, literal{interval(unit)}}, nil
+	}
+	return e, nil
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return literal{number(n)}, nil
+	case tokString:
+		p.next()
+		return literal{str(t.text)}, nil
+	case tokOp:
+		if p.accept("(") {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokIdent:
+		p.next()
+		switch t.text {
+		case "CURRENT_TIMESTAMP", "CURRENT_DATE":
+			return currentTimestamp{}, nil
+		case "INTERVAL":
+			// INTERVAL '90' DAYS
+			n, err := p.string()
+			if err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil {
+				return nil, p.errorf("bad interval %q", n)
+			}
+			unit, ok := intervalUnits[p.peek().text]
+			if !ok {
+				return nil, p.errorf("expected an interval unit such as DAYS")
+			}
+			p.next()
+			return literal{interval(time.Duration(f * float64(unit)))}, nil
+		case "DAYS", "LOWER", "UPPER", "LENGTH":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return &call{t.text, arg}, p.expect(")")
+		}
+		if attr, ok := attributes[t.text]; ok {
+			return attribute{t.text, attr}, nil
+		}
+		p.pos--
+		return nil, p.errorf("unsupported attribute or function %s", t.text)
+	}
+	return nil, p.errorf("expected a value")
+}
+
+// Values and evaluation
+
+type valueKind int
+
+const (
+	kindNumber valueKind = iota
+	kindString
+	kindTime
+	kindInterval
+	kindBool
+)
+
+type value struct {
+	kind valueKind
+	num  float64
+	str  string
+	time time.Time
+	dur  time.Duration
+	b    bool
+}
+
+func number(n float64) value         { return value{kind: kindNumber, num: n} }
+func str(s string) value             { return value{kind: kindString, str: s} }
+func timestamp(t time.Time) value    { return value{kind: kindTime, time: t} }
+func interval(d time.Duration) value { return value{kind: kindInterval, dur: d} }
+func boolean(b bool) value           { return value{kind: kindBool, b: b} }
+func (v value) truthy() bool         { return v.kind == kindBool && v.b }
+func typeError(op string) (value, error) {
+	return value{}, fmt.Errorf("can't apply %s to these values", op)
+}
+
+type expr interface {
+	eval(f File, now time.Time) (value, error)
+}
+
+type literal struct{ v value }
+
+func (l literal) eval(File, time.Time) (value, error) { return l.v, nil }
+
+type currentTimestamp struct{}
+
+func (currentTimestamp) eval(_ File, now time.Time) (value, error) { return timestamp(now), nil }
+
+type attribute struct {
+	name string
+	get  func(f File) value
+}
+
+func (a attribute) eval(f File, _ time.Time) (value, error) { return a.get(f), nil }
+
+type call struct {
+	name string
+	arg  expr
+}
+
+func (c *call) eval(f File, now time.Time) (value, error) {
+	v, err := c.arg.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	switch {
+	case c.name == "DAYS" && v.kind == kindTime:
+		return number(float64(v.time.Unix() / (24 * 60 * 60))), nil
+	case c.name == "LOWER" && v.kind == kindString:
+		return str(strings.ToLower(v.str)), nil
+	case c.name == "UPPER" && v.kind == kindString:
+		return str(strings.ToUpper(v.str)), nil
+	case c.name == "LENGTH" && v.kind == kindString:
+		return number(float64(len(v.str))), nil
+	}
+	return typeError(c.name)
+}
+
+type not struct{ e expr }
+
+func (n *not) eval(f File, now time.Time) (value, error) {
+	v, err := n.e.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	if v.kind != kindBool {
+		return typeError("NOT")
+	}
+	return boolean(!v.b), nil
+}
+
+type like struct {
+	e   expr
+	rex *regexp.Regexp
+}
+
+func (l *like) eval(f File, now time.Time) (value, error) {
+	v, err := l.e.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	if v.kind != kindString {
+		return typeError("LIKE")
+	}
+	return boolean(l.rex.MatchString(v.str)), nil
+}
+
+// SQL LIKE patterns: % matches any run of characters and _ any single character
+func likeToRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+type inList struct {
+	left expr
+	list []expr
+}
+
+func (in *inList) eval(f File, now time.Time) (value, error) {
+	v, err := in.left.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	for _, item := range in.list {
+		w, err := item.eval(f, now)
+		if err != nil {
+			return value{}, err
+		}
+		if c, ok := compare(v, w); ok && c == 0 {
+			return boolean(true), nil
+		}
+	}
+	return boolean(false), nil
+}
+
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (b *binary) eval(f File, now time.Time) (value, error) {
+	l, err := b.left.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	r, err := b.right.eval(f, now)
+	if err != nil {
+		return value{}, err
+	}
+	switch b.op {
+	case "AND", "OR":
+		if l.kind != kindBool || r.kind != kindBool {
+			return typeError(b.op)
+		}
+		if b.op == "AND" {
+			return boolean(l.b && r.b), nil
+		}
+		return boolean(l.b || r.b), nil
+	case "=", "<>", "!=", "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return typeError(b.op)
+		}
+		switch b.op {
+		case "=":
+			return boolean(c == 0), nil
+		case "<>", "!=":
+			return boolean(c != 0), nil
+		case "<":
+			return boolean(c < 0), nil
+		case "<=":
+			return boolean(c <= 0), nil
+		case ">":
+			return boolean(c > 0), nil
+		}
+		return boolean(c >= 0), nil
+	case "||":
+		if l.kind != kindString || r.kind != kindString {
+			return typeError(b.op)
+		}
+		return str(l.str + r.str), nil
+	}
+	return arithmetic(b.op, l, r)
+}
+
+func arithmetic(op string, l, r value) (value, error) {
+	switch {
+	case l.kind == kindNumber && r.kind == kindNumber:
+		switch op {
+		case "+":
+			return number(l.num + r.num), nil
+		case "-":
+			return number(l.num - r.num), nil
+		case "*":
+			return number(l.num * r.num), nil
+		case "/":
+			if r.num == 0 {
+				return value{}, fmt.Errorf("division by zero")
+			}
+			return number(l.num / r.num), nil
+		}
+	case l.kind == kindTime && r.kind == kindInterval && (op == "+" || op == "-"):
+		if op == "-" {
+			return timestamp(l.time.Add(-r.dur)), nil
+		}
+		return timestamp(l.time.Add(r.dur)), nil
+	case l.kind == kindTime && r.kind == kindTime && op == "-":
+		return interval(l.time.Sub(r.time)), nil
+	case l.kind == kindInterval && r.kind == kindInterval && (op == "+" || op == "-"):
+		if op == "-" {
+			return interval(l.dur - r.dur), nil
+		}
+		return interval(l.dur + r.dur), nil
+	case l.kind == kindNumber && r.kind == kindInterval && op == "*":
+		return interval(time.Duration(l.num * float64(r.dur))), nil
+	case l.kind == kindInterval && r.kind == kindNumber && (op == "*" || op == "/"):
+		if op == "/" {
+			if r.num == 0 {
+				return value{}, fmt.Errorf("division by zero")
+			}
+			return interval(time.Duration(float64(l.dur) / r.num)), nil
+		}
+		return interval(time.Duration(float64(l.dur) * r.num)), nil
+	}
+	return typeError(op)
+}
+
+// Compare two values of the same kind. Intervals also compare against numbers of days, which is what
+// (CURRENT_TIMESTAMP - ACCESS_TIME) > 30 means to GPFS
+func compare(l, r value) (int, bool) {
+	if l.kind == kindInterval && r.kind == kindNumber {
+		r = interval(time.Duration(r.num * float64(24*time.Hour)))
+	}
+	if l.kind == kindNumber && r.kind == kindInterval {
+		l = interval(time.Duration(l.num * float64(24*time.Hour)))
+	}
+	if l.kind != r.kind {
+		return 0, false
+	}
+	switch l.kind {
+	case kindNumber:
+		return cmp(l.num < r.num, l.num > r.num), true
+	case kindString:
+		return strings.Compare(l.str, r.str), true
+	case kindTime:
+		return cmp(l.time.Before(r.time), l.time.After(r.time)), true
+	case kindInterval:
+		return cmp(l.dur < r.dur, l.dur > r.dur), true
+	case kindBool:
+		return cmp(!l.b && r.b, l.b && !r.b), true
+	}
+	return 0, false
+}
+
+func cmp(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
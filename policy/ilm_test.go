@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+const testRules = `
+/* Site migration policy */
+RULE EXTERNAL POOL 'hsm' EXEC '/opt/ibm/ltfsee/bin/eeadm' OPTS '-p pool1@lib1'
+RULE 'keep' EXCLUDE WHERE NAME LIKE '%.log' OR PATH_NAME LIKE '/gpfs/proj/keep/%' -- never migrated
+RULE 'cold' MIGRATE FROM POOL 'data' TO POOL 'hsm'
+  WEIGHT(CURRENT_TIMESTAMP - ACCESS_TIME)
+  WHERE (CURRENT_TIMESTAMP - ACCESS_TIME) > INTERVAL '90' DAYS AND FILE_SIZE > 1048576;
+RULE 'stale' MIGRATE FROM POOL 'data' THRESHOLD(90,70) TO POOL 'hsm'
+  WHERE DAYS(CURRENT_TIMESTAMP) - DAYS(MODIFICATION_TIME) > 30 AND USER_ID NOT IN (0, 1)
+`
+
+func TestParseILM(t *testing.T) {
+	p, err := ParseILM(testRules)
+	if err != nil {
+		t.Fatalf("policy.ParseILM() = %s; want nil", err)
+	}
+	if len(p.Rules) != 3 {
+		t.Fatalf("policy.ParseILM() = %d rules; want 3", len(p.Rules))
+	}
+	if r := p.Rules[2]; r.Name != "stale" || r.Action != "MIGRATE" || r.FromPool != "data" || r.ToPool != "hsm" || r.Threshold != 90 {
+		t.Fatalf("policy.ParseILM() rule 3 = %+v; want stale from data to hsm at 90%%", r)
+	}
+
+	for _, bad := range []string{
+		"RULE 'x' MIGRATE WHERE FILE_SIZE > 1",
+		"RULE 'x' MIGRATE TO POOL 'hsm' WHERE FILESET_NAME = 'a'",
+		"RULE 'x' MIGRATE TO POOL 'hsm' WHERE (FILE_SIZE > 1",
+		"RULE 'x' MIGRATE TO POOL 'hsm' WHERE NAME = 'a",
+		"RULE 'x' MIGRATE TO POOL 'hsm' SIZE(3)",
+	} {
+		if _, err := ParseILM(bad); err == nil {
+			t.Fatalf("policy.ParseILM(%q) = nil; want error", bad)
+		}
+	}
+}
+
+func TestILMCheck(t *testing.T) {
+	p, err := ParseILM(testRules)
+	if err != nil {
+		t.Fatalf("policy.ParseILM() = %s; want nil", err)
+	}
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-100 * 24 * time.Hour)
+	month := now.Add(-40 * 24 * time.Hour)
+	tests := []struct {
+		file   File
+		reason string
+	}{
+		{File{Path: "/gpfs/proj/big.h5", Size: 2 << 20, Atime: old, Mtime: old, UID: 1000}, "will migrate to pool hsm at next policy run (rule 'cold')"},
+		{File{Path: "/gpfs/proj/run.log", Size: 2 << 20, Atime: old, Mtime: old, UID: 1000}, "excluded by rule 'keep'"},
+		{File{Path: "/gpfs/proj/keep/big.h5", Size: 2 << 20, Atime: old, Mtime: old, UID: 1000}, "excluded by rule 'keep'"},
+		{File{Path: "/gpfs/proj/small", Size: 10, Atime: old, Mtime: month, UID: 1000}, "will migrate to pool hsm once pool data is 90% full (rule 'stale')"},
+		{File{Path: "/gpfs/proj/root", Size: 10, Atime: old, Mtime: month, UID: 0}, "no migration rule matches"},
+		{File{Path: "/gpfs/proj/new", Size: 2 << 20, Atime: now, Mtime: now, UID: 1000}, "no migration rule matches"},
+		{File{Path: "/gpfs/proj/big.h5", Size: 2 << 20, Atime: old, Mtime: old, UID: 1000, Pool: "system"}, "no migration rule matches"},
+	}
+	for _, test := range tests {
+		v, err := p.Check(test.file, now)
+		if err != nil || v.Reason != test.reason {
+			t.Fatalf("policy.ILMPolicy.Check(%+v) = %q, %v; want %q", test.file, v.Reason, err, test.reason)
+		}
+	}
+
+	p, err = ParseILM("RULE 'x' MIGRATE TO POOL 'hsm' WHERE NAME > 3")
+	if err != nil {
+		t.Fatalf("policy.ParseILM() = %s; want nil", err)
+	}
+	if _, err := p.Check(File{Path: "/a"}, now); err == nil {
+		t.Fatalf("policy.ILMPolicy.Check(NAME > 3) = nil; want type error")
+	}
+}
+
+func TestILMFilesets(t *testing.T) {
+	p, err := ParseILM(`
+RULE 'scratch' EXCLUDE FOR FILESET('scratch')
+RULE 'all' MIGRATE TO POOL 'hsm'
+`)
+	if err != nil {
+		t.Fatalf("policy.ParseILM() = %s; want nil", err)
+	}
+	p.Filesets = map[string]string{"/gpfs/proj": "proj", "/gpfs/proj/scratch/": "scratch"}
+	now := time.Now()
+	tests := []struct {
+		file   File
+		reason string
+	}{
+		{File{Path: "/gpfs/proj/scratch/tmp.dat"}, "excluded by rule 'scratch'"},
+		{File{Path: "/gpfs/proj/data.h5"}, "will migrate to pool hsm at next policy run (rule 'all')"},
+		{File{Path: "/gpfs/other/data.h5"}, "will migrate to pool hsm at next policy run (rule 'all'); skipped rule 'scratch' for an unknown fileset"},
+		// A fileset given with the file wins over the junctions
+		{File{Path: "/gpfs/other/data.h5", Fileset: "scratch"}, "excluded by rule 'scratch'"},
+	}
+	for _, test := range tests {
+		v, err := p.Check(test.file, now)
+		if err != nil || v.Reason != test.reason {
+			t.Fatalf("policy.ILMPolicy.Check(%+v) = %q, %v; want %q", test.file, v.Reason, err, test.reason)
+		}
+	}
+}
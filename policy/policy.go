@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/olcf/gls/columnize"
+)
+
+// What the policy rules look at for a single file
+type File struct {
+	Path string
+	Size int64
+	// Space allocated on disk in KB
+	KBAllocated int64
+	Atime       time.Time
+	Mtime       time.Time
+	Ctime       time.Time
+	UID         uint32
+	GID         uint32
+	// GPFS storage pool the data is in, if known
+	Pool string
+	// GPFS fileset the file is in, if known
+	Fileset string
+}
+
+// Name of the fileset path is in according to junctions, which maps fileset junction paths to fileset names.
+// The deepest junction above path wins since filesets can be nested. Empty if no junction is above path
+func FilesetOf(path string, junctions map[string]string) string {
+	var best, name string
+	for junction, fileset := range junctions {
+		junction = strings.TrimSuffix(junction, "/")
+		if (path == junction || strings.HasPrefix(path, junction+"/")) && len(junction) >= len(best) {
+			best, name = junction, fileset
+		}
+	}
+	return name
+}
+
+// Which timestamp Rules.MinAge is measured from
+const (
+	AgeByAccess       = "access"
+	AgeByModification = "modification"
+)
+
+// A site's migration policy in simple terms. Files are migrated once none of the rules hold them back.
+// Zero values disable a rule
+type Rules struct {
+	// Files smaller than MinSize bytes are never migrated
+	MinSize int64
+	// Files larger than MaxSize bytes can't be migrated
+	MaxSize int64
+	// Path prefixes that are never migrated. GPFS filesets are excluded by their junction path
+	ExcludePaths []string
+	// Shell patterns (see filepath.Match) matched against the file name
+	ExcludePatterns []string
+	// Files are only migrated once they haven't been accessed (or modified, see AgeBy) for this long
+	MinAge time.Duration
+	AgeBy  string
+}
+
+// The outcome of checking a file against the policy
+type Verdict struct {
+	Eligible bool
+	// Why the file is or isn't eligible, e.g. "smaller than the 1.0 MB minimum"
+	Reason string
+	// When the file becomes eligible by growing old enough. Zero if age is not what holds it back
+	EligibleAt time.Time
+	// The ILM rule that decided the verdict, if it came from an ILMPolicy
+	Rule string
+}
+
+// Check f against the rules at the time now. Exclusions are checked first since they are permanent,
+// the age threshold last since it is the only rule that resolves itself
+func (r *Rules) Check(f File, now time.Time) Verdict {
+	for _, prefix := range r.ExcludePaths {
+		if f.Path == prefix || strings.HasPrefix(f.Path, strings.TrimSuffix(prefix, "/")+"/") {
+			return Verdict{Reason: "in excluded path " + prefix}
+		}
+	}
+	name := filepath.Base(f.Path)
+	for _, pattern := range r.ExcludePatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return Verdict{Reason: "name matches excluded pattern " + pattern}
+		}
+	}
+	if r.MaxSize > 0 && f.Size > r.MaxSize {
+		return Verdict{Reason: "larger than the " + columnize.HumanizeSize(r.MaxSize) + " maximum"}
+	}
+	if r.MinSize > 0 && f.Size < r.MinSize {
+		return Verdict{Reason: "smaller than the " + columnize.HumanizeSize(r.MinSize) + " minimum"}
+	}
+	if r.MinAge > 0 {
+		since, what := f.Atime, "accessed"
+		if r.AgeBy == AgeByModification {
+			since, what = f.Mtime, "modified"
+		}
+		if at := since.Add(r.MinAge); at.After(now) {
+			return Verdict{
+				Reason:     fmt.Sprintf("%s too recently, eligible after %s", what, at.Format("Jan 02 15:04 2006")),
+				EligibleAt: at,
+			}
+		}
+	}
+	return Verdict{Eligible: true, Reason: "eligible for migration"}
+}
@@ -0,0 +1,49 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	rules := Rules{
+		MinSize:         1000,
+		MaxSize:         1000000,
+		ExcludePaths:    []string{"/gpfs/proj/scratch/"},
+		ExcludePatterns: []string{"*.tmp"},
+		MinAge:          30 * 24 * time.Hour,
+		AgeBy:           AgeByAccess,
+	}
+	old := now.Add(-60 * 24 * time.Hour)
+	recent := now.Add(-24 * time.Hour)
+	tests := []struct {
+		file     File
+		eligible bool
+		reason   string
+	}{
+		{File{Path: "/gpfs/proj/data.h5", Size: 5000, Atime: old}, true, "eligible for migration"},
+		{File{Path: "/gpfs/proj/scratch/data.h5", Size: 5000, Atime: old}, false, "in excluded path /gpfs/proj/scratch/"},
+		{File{Path: "/gpfs/proj/scratchpad/data.h5", Size: 5000, Atime: old}, true, "eligible for migration"},
+		{File{Path: "/gpfs/proj/run.tmp", Size: 5000, Atime: old}, false, "name matches excluded pattern *.tmp"},
+		{File{Path: "/gpfs/proj/huge", Size: 2000000, Atime: old}, false, "larger than the 1.0 MB maximum"},
+		{File{Path: "/gpfs/proj/tiny", Size: 10, Atime: old}, false, "smaller than the 1.0 kB minimum"},
+		{File{Path: "/gpfs/proj/new", Size: 5000, Atime: recent, Mtime: old}, false, "accessed too recently, eligible after Oct 30 12:00 2022"},
+	}
+	for _, test := range tests {
+		v := rules.Check(test.file, now)
+		if v.Eligible != test.eligible || v.Reason != test.reason {
+			t.Fatalf("policy.Check(%v) = %v; want eligible == %v, reason %q", test.file, v, test.eligible, test.reason)
+		}
+	}
+
+	rules.AgeBy = AgeByModification
+	file := File{Path: "/gpfs/proj/new", Size: 5000, Atime: recent, Mtime: old}
+	if v := rules.Check(file, now); !v.Eligible {
+		t.Fatalf("policy.Check(%v, age by modification) = %v; want eligible", file, v)
+	}
+	file.Mtime = recent
+	if v := rules.Check(file, now); v.EligibleAt != recent.Add(rules.MinAge) {
+		t.Fatalf("policy.Check(%v).EligibleAt = %s; want %s", file, v.EligibleAt, recent.Add(rules.MinAge))
+	}
+}
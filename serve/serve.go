@@ -0,0 +1,330 @@
+// Package serve is `gls :serve`, a small HTTP API answering storage state queries for web portals and notebooks.
+// Every endpoint takes GET parameters and answers with JSON:
+//
+//	/v1/list?path=DIR[&all=true]      the files in DIR
+//	/v1/stat?path=P[&path=P2...]      the given files
+//	/v1/summary?path=DIR              files and bytes per state below DIR
+//
+// Entries are storage.Entry, the same as the gls/storage library returns and `gls :stat --json` starts with, plus an
+// error for files that couldn't be looked up. Only paths below Roots are answered, and files are looked up with the permissions of whoever runs gls
+// serve, so Roots should not reach further than every user of the API may see.
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Most paths /v1/stat takes in one request
+const MaxPaths = 1000
+
+// Files looked up at once by /v1/summary. Bounds memory on big trees while keeping every worker busy
+const summaryBatch = 1000
+
+// A looked up file. Error is set instead of the storage state when the lookup failed
+type Item struct {
+	storage.Entry
+	Error string `json:"error,omitempty"`
+}
+
+// Answer of /v1/list
+type Listing struct {
+	Path    string `json:"path"`
+	Entries []Item `json:"entries"`
+}
+
+// Answer of /v1/stat, in the order the paths were given
+type Stats struct {
+	Entries []Item `json:"entries"`
+}
+
+// Files and bytes in one state
+type Count struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Answer of /v1/summary. Only regular files are counted
+type Summary struct {
+	Path   string           `json:"path"`
+	Files  int              `json:"files"`
+	Bytes  int64            `json:"bytes"`
+	States map[string]Count `json:"states"`
+	// Files and directories that couldn't be read or looked up
+	Errors int `json:"errors"`
+	// The time limit ran out before the whole tree was walked
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Answer when a request fails as a whole
+type Error struct {
+	Error string `json:"error"`
+}
+
+// The API as an http.Handler. The zero value is not usable, use New
+type Server struct {
+	// Looks the files up. Its Workers are the lookups in flight per request
+	Client *storage.Client
+	// Only paths below these are answered, after following symbolic links
+	Roots []string
+	// Longest a request may take. /v1/summary answers with what it has so far, the others fail
+	Timeout time.Duration
+
+	// One slot per request allowed in flight at once
+	slots chan struct{}
+	mux   *http.ServeMux
+}
+
+// A Server answering for files below roots with at most maxRequests requests in flight
+func New(client *storage.Client, roots []string, timeout time.Duration, maxRequests int) *Server {
+	if maxRequests < 1 {
+		maxRequests = 1
+	}
+	// Compared with paths after their links are followed
+	var real []string
+	for _, root := range roots {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		real = append(real, filepath.Clean(root))
+	}
+	s := &Server{
+		Client:  client,
+		Roots:   real,
+		Timeout: timeout,
+		slots:   make(chan struct{}, maxRequests),
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/v1/list", s.list)
+	s.mux.HandleFunc("/v1/stat", s.stat)
+	s.mux.HandleFunc("/v1/summary", s.summary)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		reply(w, http.StatusMethodNotAllowed, Error{"only GET is supported"})
+		return
+	}
+	// Busy servers turn requests away rather than queueing them past their time limit
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		reply(w, http.StatusServiceUnavailable, Error{"too many requests in flight, try again later"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.Timeout)
+	defer cancel()
+	start := time.Now()
+	s.mux.ServeHTTP(w, r.WithContext(ctx))
+	log.Debug().Msgf("%s %s in %s", r.Method, r.URL, time.Since(start))
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// The path with its symbolic links followed if it is absolute and below one of the roots. That is the path to
+// look up, so a link swapped after the check can't lead elsewhere
+func (s *Server) allowed(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", errors.New("path must be absolute: " + path)
+	}
+	path = filepath.Clean(path)
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// Missing files are reported per file, as long as they'd be below a root
+		real = path
+	}
+	if !s.below(real) {
+		return "", errors.New("not below an allowed root: " + path)
+	}
+	return real, nil
+}
+
+// Whether the absolute path real is one of the roots or below one
+func (s *Server) below(real string) bool {
+	for _, root := range s.Roots {
+		if real == root || strings.HasPrefix(real, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop what the entry of a link tells about its target when the target is outside the roots
+func (s *Server) confine(item *Item) {
+	if item.Target == "" {
+		return
+	}
+	target := item.Target
+	if !filepath.IsAbs(target) {
+		// Broken links keep the target as written
+		target = filepath.Join(filepath.Dir(item.Path), target)
+	}
+	if s.below(target) {
+		return
+	}
+	item.State, item.Pool, item.TapeCopies, item.Target = storage.Unknown, "", nil, ""
+	item.Error = "link target not below an allowed root"
+}
+
+// Look up paths with the client's workers, in the order given
+func (s *Server) lookup(ctx context.Context, paths []string) ([]Item, error) {
+	index := make(map[string]int, len(paths))
+	for i, path := range paths {
+		index[path] = i
+	}
+	items := make([]Item, len(paths))
+	done := 0
+	for r := range s.Client.StatMany(ctx, paths) {
+		item := Item{Entry: r.Entry}
+		if r.Err != nil {
+			item.Error = r.Err.Error()
+		}
+		s.confine(&item)
+		items[index[r.Entry.Path]] = item
+		done++
+	}
+	if done < len(paths) {
+		return nil, ctx.Err()
+	}
+	return items, nil
+}
+
+// 504 for requests that ran out of time, 500 otherwise
+func failed(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	reply(w, status, Error{err.Error()})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	dir, err := s.allowed(r.URL.Query().Get("path"))
+	if err != nil {
+		reply(w, http.StatusForbidden, Error{err.Error()})
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		reply(w, http.StatusNotFound, Error{err.Error()})
+		return
+	}
+	var paths []string
+	for _, e := range entries {
+		if all || !strings.HasPrefix(e.Name(), ".") {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	items, err := s.lookup(r.Context(), paths)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	reply(w, http.StatusOK, Listing{Path: dir, Entries: items})
+}
+
+func (s *Server) stat(w http.ResponseWriter, r *http.Request) {
+	paths := r.URL.Query()["path"]
+	if len(paths) == 0 || len(paths) > MaxPaths {
+		reply(w, http.StatusBadRequest, Error{"expected between 1 and " + strconv.Itoa(MaxPaths) + " path parameters"})
+		return
+	}
+	seen := make(map[string]bool)
+	var unique []string
+	for i, path := range paths {
+		path, err := s.allowed(path)
+		if err != nil {
+			reply(w, http.StatusForbidden, Error{err.Error()})
+			return
+		}
+		paths[i] = path
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	items, err := s.lookup(r.Context(), unique)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	byPath := make(map[string]Item, len(items))
+	for _, item := range items {
+		byPath[item.Path] = item
+	}
+	stats := Stats{Entries: make([]Item, len(paths))}
+	for i, path := range paths {
+		stats.Entries[i] = byPath[path]
+	}
+	reply(w, http.StatusOK, stats)
+}
+
+func (s *Server) summary(w http.ResponseWriter, r *http.Request) {
+	root, err := s.allowed(r.URL.Query().Get("path"))
+	if err != nil {
+		reply(w, http.StatusForbidden, Error{err.Error()})
+		return
+	}
+	if _, err := os.Stat(root); err != nil {
+		reply(w, http.StatusNotFound, Error{err.Error()})
+		return
+	}
+	ctx := r.Context()
+	sum := Summary{Path: root, States: make(map[string]Count)}
+	var batch []string
+	flush := func() {
+		for r := range s.Client.StatMany(ctx, batch) {
+			if r.Err != nil {
+				sum.Errors++
+				continue
+			}
+			c := sum.States[r.Entry.State.String()]
+			c.Files++
+			c.Bytes += r.Entry.Size
+			sum.States[r.Entry.State.String()] = c
+			sum.Files++
+			sum.Bytes += r.Entry.Size
+		}
+		batch = batch[:0]
+	}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			sum.Errors++
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if batch = append(batch, path); len(batch) == summaryBatch {
+				flush()
+			}
+		}
+		return nil
+	})
+	flush()
+	// Files of the last batch that weren't looked up in time aren't counted either way
+	sum.Partial = err != nil || ctx.Err() != nil
+	reply(w, http.StatusOK, sum)
+}
@@ -0,0 +1,171 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olcf/gls/fake"
+	"github.com/olcf/gls/storage"
+)
+
+const testFixture = `{
+	"files": {
+		"/proj/a": {"size": 2, "state": "migrated"},
+		"/proj/b": {"size": 5},
+		"/proj/.hidden": {},
+		"/proj/sub/c": {"size": 3, "state": "migrated"},
+		"/proj/sub/bad": {"error": "no attributes"}
+	}
+}`
+
+// A Server for /proj of the fixture created in a temporary directory, and the path of /proj. The client may look up
+// anything in the fixture
+func testServer(t *testing.T, fixture string) (*Server, string) {
+	fx, err := fake.Parse(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	backend, err := fx.Create(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "proj")
+	client := &storage.Client{Backend: backend, Roots: []string{root}, Workers: 2}
+	return New(client, []string{dir}, time.Minute, 2), dir
+}
+
+// GET path with query on s, decoding the answer into v. Returns the status code
+func get(t *testing.T, s *Server, path string, query url.Values, v interface{}) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil))
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s?%s: %s\n%s", path, query.Encode(), err, w.Body.String())
+	}
+	return w.Code
+}
+
+func TestList(t *testing.T) {
+	s, dir := testServer(t, testFixture)
+	var have Listing
+	if code := get(t, s, "/v1/list", url.Values{"path": {dir}}, &have); code != http.StatusOK {
+		t.Fatalf("GET /v1/list = %v; want %v", code, http.StatusOK)
+	}
+	var names []string
+	for _, e := range have.Entries {
+		names = append(names, e.Name)
+	}
+	if want := []string{"a", "b", "sub"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Fatalf("GET /v1/list = %v; want %v", names, want)
+	}
+	if have.Entries[0].State != storage.Migrated || !have.Entries[2].IsDir {
+		t.Fatalf("GET /v1/list = %+v; want a migrated and sub a directory", have.Entries)
+	}
+
+	if code := get(t, s, "/v1/list", url.Values{"path": {dir}, "all": {"true"}}, &have); code != http.StatusOK || len(have.Entries) != 4 {
+		t.Fatalf("GET /v1/list?all=true = %v, %v entries; want %v, %v", code, len(have.Entries), http.StatusOK, 4)
+	}
+}
+
+func TestStat(t *testing.T) {
+	s, dir := testServer(t, testFixture)
+	paths := []string{filepath.Join(dir, "sub/bad"), filepath.Join(dir, "a"), filepath.Join(dir, "a")}
+	var have Stats
+	if code := get(t, s, "/v1/stat", url.Values{"path": paths}, &have); code != http.StatusOK {
+		t.Fatalf("GET /v1/stat = %v; want %v", code, http.StatusOK)
+	}
+	if len(have.Entries) != 3 {
+		t.Fatalf("GET /v1/stat = %v entries; want %v", len(have.Entries), 3)
+	}
+	if e := have.Entries[0]; e.Path != paths[0] || len(e.Error) == 0 {
+		t.Fatalf("GET /v1/stat entry 0 = %+v; want an error for %v", e, paths[0])
+	}
+	if e := have.Entries[2]; e.Path != paths[2] || e.State != storage.Migrated || e.Size != 2 {
+		t.Fatalf("GET /v1/stat entry 2 = %+v; want %v migrated with 2 bytes", e, paths[2])
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s, dir := testServer(t, testFixture)
+	var have Summary
+	if code := get(t, s, "/v1/summary", url.Values{"path": {dir}}, &have); code != http.StatusOK {
+		t.Fatalf("GET /v1/summary = %v; want %v", code, http.StatusOK)
+	}
+	want := Summary{Path: dir, Files: 4, Bytes: 10, Errors: 1, States: map[string]Count{
+		"migrated": {Files: 2, Bytes: 5},
+		"resident": {Files: 2, Bytes: 5},
+	}}
+	if have.Files != want.Files || have.Bytes != want.Bytes || have.Errors != want.Errors || have.Partial ||
+		have.States["migrated"] != want.States["migrated"] || have.States["resident"] != want.States["resident"] {
+		t.Fatalf("GET /v1/summary = %+v; want %+v", have, want)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	s, dir := testServer(t, testFixture)
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/etc", http.StatusForbidden},
+		{"relative", http.StatusForbidden},
+		{dir + "/../", http.StatusForbidden},
+		{filepath.Join(dir, "escape"), http.StatusForbidden},
+		{filepath.Join(dir, "missing"), http.StatusNotFound},
+	}
+	for _, test := range tests {
+		var have Error
+		if code := get(t, s, "/v1/list", url.Values{"path": {test.path}}, &have); code != test.want {
+			t.Fatalf("GET /v1/list?path=%v = %v; want %v", test.path, code, test.want)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	// The client can look the outside file up, the API still mustn't tell
+	s, dir := testServer(t, `{
+		"files": {
+			"/proj/a": {"state": "migrated"},
+			"/proj/links/c": {"type": "link", "target": "/proj/a"},
+			"/proj/links/a": {"type": "link", "target": "/outside/a"},
+			"/outside/a": {"state": "migrated"}
+		}
+	}`)
+	links := filepath.Join(dir, "links")
+	var have Listing
+	if code := get(t, s, "/v1/list", url.Values{"path": {links}}, &have); code != http.StatusOK || len(have.Entries) != 2 {
+		t.Fatalf("GET /v1/list = %v, %v entries; want %v, %v", code, len(have.Entries), http.StatusOK, 2)
+	}
+	if e := have.Entries[1]; e.State != storage.Migrated || e.Target != filepath.Join(dir, "a") {
+		t.Fatalf("GET /v1/list link inside = %+v; want migrated with its target", e)
+	}
+	if e := have.Entries[0]; e.State != storage.Unknown || e.Target != "" {
+		t.Fatalf("GET /v1/list link outside = %+v; want unknown without a target", e)
+	}
+	if e := have.Entries[0]; e.Error != "link target not below an allowed root" {
+		t.Fatalf("GET /v1/list link outside = %+v; want it confined", e)
+	}
+}
+
+func TestBusy(t *testing.T) {
+	s, dir := testServer(t, testFixture)
+	// Every slot is taken
+	for i := 0; i < cap(s.slots); i++ {
+		s.slots <- struct{}{}
+	}
+	var have Error
+	if code := get(t, s, "/v1/list", url.Values{"path": {dir}}, &have); code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /v1/list when busy = %v; want %v", code, http.StatusServiceUnavailable)
+	}
+}
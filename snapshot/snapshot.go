@@ -0,0 +1,245 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olcf/gls/columnize"
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/storage"
+
+	"github.com/spf13/afero"
+)
+
+// Bumped whenever the file format changes in a way older versions of gls can't read. Version 2 stores states as
+// storage.State names instead of the labels of the listing
+const Version = 2
+
+// The storage state of every file below Roots at the time the snapshot was taken
+type Snapshot struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Roots   []string  `json:"roots"`
+	// Sorted by path
+	Entries []Entry `json:"entries"`
+}
+
+// A single file. Field names are kept short since a snapshot holds one of these per file
+type Entry struct {
+	Path  string `json:"p"`
+	Size  int64  `json:"s"`
+	Mtime int64  `json:"m"`
+	// Unknown for files that aren't on GPFS
+	State storage.State `json:"t"`
+}
+
+// Returns the storage state of the file at path
+type StateFunc func(path string) storage.State
+
+// Walk every root on fsys and record the regular files below it. Files that disappear or can't be read while
+// walking are skipped rather than failing the whole snapshot
+func Take(fsys afero.Fs, roots []string, state StateFunc) (*Snapshot, error) {
+	s := &Snapshot{Version: Version, Created: time.Now(), Roots: roots}
+	for _, root := range roots {
+		err := afero.Walk(fsys, root, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				if path == root {
+					return err
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			s.Entries = append(s.Entries, Entry{
+				Path:  path,
+				Size:  info.Size(),
+				Mtime: info.ModTime().Unix(),
+				State: state(path),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(s.Entries, func(i, j int) bool {
+		return s.Entries[i].Path < s.Entries[j].Path
+	})
+	return s, nil
+}
+
+// Write the snapshot to w as gzipped JSON
+func (s *Snapshot) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Write the snapshot to the file at path
+func (s *Snapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read a snapshot written by Write
+func Read(r io.Reader) (*Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var s Snapshot
+	if err := json.NewDecoder(zr).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d, this gls reads version %d", s.Version, Version)
+	}
+	return &s, nil
+}
+
+// Read the snapshot in the file at path
+func Load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// What happened to a file between two snapshots
+type Kind string
+
+const (
+	Added   Kind = "added"
+	Removed Kind = "removed"
+	// The storage state changed, e.g. Resident to Migrated, or Migrated to Premigrated after a recall
+	StateChanged Kind = "state"
+	SizeChanged  Kind = "size"
+)
+
+// A single difference between two snapshots. A file whose state and size both changed gets one of each
+type Change struct {
+	Path string `json:"path"`
+	Kind Kind   `json:"kind"`
+	// Unknown on the side where the file doesn't exist
+	OldState storage.State `json:"old_state"`
+	NewState storage.State `json:"new_state"`
+	OldSize  int64         `json:"old_size"`
+	NewSize  int64         `json:"new_size"`
+}
+
+// Compare two snapshots. Only files below a root of both snapshots are compared, so a snapshot of a
+// whole project can be diffed against a fresh look at one of its directories
+func Diff(old, new *Snapshot) []Change {
+	var changes []Change
+	inScope := func(path string) bool {
+		return under(path, old.Roots) && under(path, new.Roots)
+	}
+	i, j := 0, 0
+	for i < len(old.Entries) || j < len(new.Entries) {
+		switch {
+		case j == len(new.Entries) || (i < len(old.Entries) && old.Entries[i].Path < new.Entries[j].Path):
+			o := old.Entries[i]
+			if inScope(o.Path) {
+				changes = append(changes, Change{Path: o.Path, Kind: Removed, OldState: o.State, NewState: storage.Unknown, OldSize: o.Size})
+			}
+			i++
+		case i == len(old.Entries) || new.Entries[j].Path < old.Entries[i].Path:
+			n := new.Entries[j]
+			if inScope(n.Path) {
+				changes = append(changes, Change{Path: n.Path, Kind: Added, OldState: storage.Unknown, NewState: n.State, NewSize: n.Size})
+			}
+			j++
+		default:
+			o, n := old.Entries[i], new.Entries[j]
+			if o.State != n.State {
+				changes = append(changes, Change{Path: n.Path, Kind: StateChanged, OldState: o.State, NewState: n.State, OldSize: o.Size, NewSize: n.Size})
+			}
+			if o.Size != n.Size {
+				changes = append(changes, Change{Path: n.Path, Kind: SizeChanged, OldState: o.State, NewState: n.State, OldSize: o.Size, NewSize: n.Size})
+			}
+			i++
+			j++
+		}
+	}
+	return changes
+}
+
+// Whether path is one of roots or below one of them
+func under(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the changes as aligned "kind  detail  path" lines, with the states labelled like a listing labels them
+func PrintText(w io.Writer, changes []Change) error {
+	table := columnize.NewTable(w, false, 2)
+	for _, c := range changes {
+		var detail string
+		switch c.Kind {
+		case StateChanged:
+			detail = label(c.OldState) + " -> " + label(c.NewState)
+		case SizeChanged:
+			detail = fmt.Sprintf("%d -> %d bytes", c.OldSize, c.NewSize)
+		case Added:
+			detail = label(c.NewState)
+		case Removed:
+			detail = label(c.OldState)
+		}
+		table.PrintLine([]string{string(c.Kind), detail, c.Path})
+	}
+	return table.Flush()
+}
+
+// Write the changes as an indented JSON array
+func PrintJSON(w io.Writer, changes []Change) error {
+	if changes == nil {
+		changes = []Change{}
+	}
+	out, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// The listing's label for a state, e.g. config.Ret2Str, or - for files without one
+func label(state storage.State) string {
+	switch state {
+	case storage.Resident:
+		return config.Ret0Str
+	case storage.Premigrated:
+		return config.Ret1Str
+	case storage.Migrated:
+		return config.Ret2Str
+	}
+	return "-"
+}
@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olcf/gls/storage"
+
+	"github.com/spf13/afero"
+)
+
+func TestTakeAndRead(t *testing.T) {
+	dir := t.TempDir()
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	checkErr(os.WriteFile(filepath.Join(dir, "sub", "b"), []byte("hello"), 0644))
+	checkErr(os.WriteFile(filepath.Join(dir, "a"), []byte("hi"), 0644))
+	checkErr(os.Symlink("a", filepath.Join(dir, "link")))
+
+	s, err := Take(afero.NewOsFs(), []string{dir}, func(path string) storage.State { return storage.Migrated })
+	checkErr(err)
+	var paths []string
+	for _, e := range s.Entries {
+		paths = append(paths, e.Path)
+	}
+	want := []string{filepath.Join(dir, "a"), filepath.Join(dir, "sub", "b")}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("snapshot.Take(%s) paths = %v; want %v", dir, paths, want)
+	}
+
+	var buf bytes.Buffer
+	checkErr(s.Write(&buf))
+	have, err := Read(&buf)
+	checkErr(err)
+	if !reflect.DeepEqual(have.Entries, s.Entries) || !have.Created.Equal(s.Created) {
+		t.Fatalf("snapshot.Read(Write(%v)) = %v; want %v", s, have, s)
+	}
+
+	if _, err := Take(afero.NewOsFs(), []string{filepath.Join(dir, "missing")}, nil); err == nil {
+		t.Fatalf("snapshot.Take(missing) = nil; want error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := &Snapshot{Roots: []string{"/p"}, Entries: []Entry{
+		{Path: "/p/a", Size: 1, State: storage.Resident},
+		{Path: "/p/b", Size: 2, State: storage.Migrated},
+		{Path: "/p/c", Size: 3, State: storage.Resident},
+		{Path: "/p/sub/d", Size: 4, State: storage.Resident},
+	}}
+	new := &Snapshot{Roots: []string{"/p"}, Entries: []Entry{
+		{Path: "/p/a", Size: 1, State: storage.Migrated},
+		{Path: "/p/b", Size: 5, State: storage.Premigrated},
+		{Path: "/p/e", Size: 6, State: storage.Resident},
+		{Path: "/p/sub/d", Size: 4, State: storage.Resident},
+	}}
+	want := []Change{
+		{Path: "/p/a", Kind: StateChanged, OldState: storage.Resident, NewState: storage.Migrated, OldSize: 1, NewSize: 1},
+		{Path: "/p/b", Kind: StateChanged, OldState: storage.Migrated, NewState: storage.Premigrated, OldSize: 2, NewSize: 5},
+		{Path: "/p/b", Kind: SizeChanged, OldState: storage.Migrated, NewState: storage.Premigrated, OldSize: 2, NewSize: 5},
+		{Path: "/p/c", Kind: Removed, OldState: storage.Resident, NewState: storage.Unknown, OldSize: 3},
+		{Path: "/p/e", Kind: Added, OldState: storage.Unknown, NewState: storage.Resident, NewSize: 6},
+	}
+	if have := Diff(old, new); !reflect.DeepEqual(have, want) {
+		t.Fatalf("snapshot.Diff() = %v; want %v", have, want)
+	}
+
+	// Files outside the newer snapshot's roots are not reported as removed
+	sub := &Snapshot{Roots: []string{"/p/sub"}, Entries: []Entry{{Path: "/p/sub/d", Size: 4, State: storage.Migrated}}}
+	want = []Change{{Path: "/p/sub/d", Kind: StateChanged, OldState: storage.Resident, NewState: storage.Migrated, OldSize: 4, NewSize: 4}}
+	if have := Diff(old, sub); !reflect.DeepEqual(have, want) {
+		t.Fatalf("snapshot.Diff(sub) = %v; want %v", have, want)
+	}
+}
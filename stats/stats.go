@@ -0,0 +1,236 @@
+// Package stats records where a listing spends its time for --stats: directory reads, lstat, owner lookups,
+// attribute checks, pool and attribute lookups for --pools and rendering, the latency of each attribute check and
+// how many workers did the lookups. It tells whether a slow listing is waiting on GPFS, on the user and group
+// database or on gls itself.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olcf/gls/columnize"
+)
+
+// Phases of a listing
+const (
+	DirRead = "dir_read"
+	Lstat   = "lstat"
+	Owner   = "owner_lookup"
+	Attr    = "attr_check"
+	Pool    = "storage_pool"
+	Dump    = "attr_dump"
+	Render  = "render"
+)
+
+// In the order they are reported
+var phases = []string{DirRead, Lstat, Owner, Attr, Pool, Dump, Render}
+
+// Collects timings from any number of goroutines. A nil Recorder records nothing, so callers don't need to check
+// whether --stats is on
+type Recorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	total  map[string]time.Duration
+	calls  map[string]int
+	errors map[string]int
+	// Every attribute check, for the percentiles
+	attr    []time.Duration
+	entries int
+	cached  int
+	workers int
+	peak    int
+	limit   int
+}
+
+// A Recorder timing the run from now
+func New() *Recorder {
+	return &Recorder{
+		start:  time.Now(),
+		total:  make(map[string]time.Duration),
+		calls:  make(map[string]int),
+		errors: make(map[string]int),
+	}
+}
+
+// Count a call in phase that started at start and failed if failed is set
+func (r *Recorder) Done(phase string, start time.Time, failed bool) {
+	if r == nil {
+		return
+	}
+	d := time.Since(start)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total[phase] += d
+	r.calls[phase]++
+	if failed {
+		r.errors[phase]++
+	}
+	if phase == Attr {
+		r.attr = append(r.attr, d)
+	}
+}
+
+// Count a call in phase that started at start. Meant for defer r.Since(phase, time.Now())
+func (r *Recorder) Since(phase string, start time.Time) {
+	r.Done(phase, start, false)
+}
+
+// Count a listed entry, and whether its state came from the cache instead of an attribute check
+func (r *Recorder) Entry(cached bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries++
+	if cached {
+		r.cached++
+	}
+}
+
+// Count workers launched to look files up
+func (r *Recorder) Workers(n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers += n
+}
+
+// Record the most lookups in flight at once and the bound the limiter ended with, see throttle.Limiter
+func (r *Recorder) Limiter(peak, limit int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peak, r.limit = peak, limit
+}
+
+// Time spent in one phase. Total adds up every call, so phases done by several workers at once can take longer
+// than the whole run
+type Phase struct {
+	Name   string        `json:"name"`
+	Calls  int           `json:"calls"`
+	Total  time.Duration `json:"total_ns"`
+	Errors int           `json:"errors"`
+}
+
+// Latency of the attribute checks
+type Latency struct {
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+type Report struct {
+	Wall    time.Duration `json:"wall_ns"`
+	Entries int           `json:"entries"`
+	// Entries whose state came from the cache (see --cache)
+	Cached      int     `json:"cached"`
+	Phases      []Phase `json:"phases"`
+	AttrLatency Latency `json:"attr_latency"`
+	// Worker goroutines launched, the most lookups in flight at once and the limiter's bound at the end
+	Workers     int `json:"workers"`
+	PeakWorkers int `json:"peak_workers"`
+	FinalLimit  int `json:"final_limit"`
+}
+
+// Everything recorded so far
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := Report{
+		Wall:        time.Since(r.start),
+		Entries:     r.entries,
+		Cached:      r.cached,
+		Workers:     r.workers,
+		PeakWorkers: r.peak,
+		FinalLimit:  r.limit,
+	}
+	for _, p := range phases {
+		rep.Phases = append(rep.Phases, Phase{Name: p, Calls: r.calls[p], Total: r.total[p], Errors: r.errors[p]})
+	}
+	attr := append([]time.Duration(nil), r.attr...)
+	sort.Slice(attr, func(i, j int) bool { return attr[i] < attr[j] })
+	rep.AttrLatency = Latency{
+		P50: percentile(attr, 50),
+		P90: percentile(attr, 90),
+		P99: percentile(attr, 99),
+	}
+	if len(attr) > 0 {
+		rep.AttrLatency.Max = attr[len(attr)-1]
+	}
+	return rep
+}
+
+// Nearest-rank percentile p of sorted
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Round durations to something readable
+func short(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// "1 call", "2 calls"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// Write the report as a table
+func (rep Report) PrintText(w io.Writer) error {
+	rows := [][]string{
+		{"Wall time:", short(rep.Wall)},
+		{"Entries:", strconv.Itoa(rep.Entries) + " (" + strconv.Itoa(rep.Cached) + " from the cache)"},
+		{"Workers:", fmt.Sprintf("%d launched, at most %d looking files up at once, limit %d at the end", rep.Workers, rep.PeakWorkers, rep.FinalLimit)},
+	}
+	for _, p := range rep.Phases {
+		line := short(p.Total) + " in " + plural(p.Calls, "call")
+		if p.Errors > 0 {
+			line += fmt.Sprintf(", %d failed", p.Errors)
+		}
+		rows = append(rows, []string{p.Name + ":", line})
+	}
+	l := rep.AttrLatency
+	rows = append(rows, []string{"attr_check latency:", fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s", short(l.P50), short(l.P90), short(l.P99), short(l.Max))})
+	table := columnize.NewTable(w, false, 2)
+	for _, row := range rows {
+		table.PrintLine(row)
+	}
+	return table.Flush()
+}
+
+// Write the report as indented JSON
+func (rep Report) PrintJSON(w io.Writer) error {
+	out, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{nil, 50, 0},
+		{sorted[:1], 99, 1},
+		{sorted, 50, 50},
+		{sorted, 90, 90},
+		{sorted, 99, 99},
+		{sorted[:10], 50, 5},
+		{sorted[:10], 99, 10},
+	}
+	for _, test := range tests {
+		if have := percentile(test.sorted, test.p); have != test.want {
+			t.Fatalf("stats.percentile(%v values, %v) = %v; want %v", len(test.sorted), test.p, have, test.want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := New()
+	r.Done(Attr, time.Now(), false)
+	r.Done(Attr, time.Now(), true)
+	r.Since(Lstat, time.Now())
+	r.Since(Pool, time.Now())
+	r.Entry(false)
+	r.Entry(true)
+	r.Workers(3)
+	r.Workers(2)
+	r.Limiter(4, 6)
+
+	rep := r.Report()
+	if rep.Entries != 2 || rep.Cached != 1 || rep.Workers != 5 || rep.PeakWorkers != 4 || rep.FinalLimit != 6 {
+		t.Fatalf("stats.Report() = %+v; want 2 entries, 1 cached, 5 workers, peak 4, limit 6", rep)
+	}
+	phases := make(map[string]Phase)
+	for _, p := range rep.Phases {
+		phases[p.Name] = p
+	}
+	if p := phases[Attr]; p.Calls != 2 || p.Errors != 1 {
+		t.Fatalf("stats.Report() %v = %+v; want 2 calls, 1 error", Attr, p)
+	}
+	if p := phases[Lstat]; p.Calls != 1 || p.Errors != 0 {
+		t.Fatalf("stats.Report() %v = %+v; want 1 call, 0 errors", Lstat, p)
+	}
+	// Only attribute checks make up the latency
+	if p := phases[Pool]; p.Calls != 1 || len(r.attr) != 2 {
+		t.Fatalf("stats.Report() %v = %+v with %v attr_check latencies; want 1 call, 2 latencies", Pool, p, len(r.attr))
+	}
+	if len(rep.Phases) != len(phases) || phases[Render].Calls != 0 {
+		t.Fatalf("stats.Report() phases = %+v; want every phase once", rep.Phases)
+	}
+
+	var out strings.Builder
+	if err := rep.PrintText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"attr_check:", "in 2 calls, 1 failed", "in 1 call\n", "attr_check latency:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("stats.PrintText() = \n%s\nwant it to contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestNil(t *testing.T) {
+	// Recording on a nil Recorder is a no-op
+	var r *Recorder
+	r.Done(Attr, time.Now(), true)
+	r.Since(Render, time.Now())
+	r.Entry(true)
+	r.Workers(1)
+	r.Limiter(1, 1)
+}
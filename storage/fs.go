@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Links ResolveLink follows before giving up, like the kernel's ELOOP limit
+const maxSymlinkHops = 40
+
+// Whether path is one of roots or below one. Only such files have a storage state. gls and Client both decide with
+// this, from the path of the file itself (for a link, the link's path rather than its target's)
+func OnGPFS(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// os.Lstat on fsys. Filesystems without symbolic links (no afero.Lstater) are treated as if they had none
+func Lstat(fsys afero.Fs, path string) (fs.FileInfo, error) {
+	if lstater, ok := fsys.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(path)
+		return info, err
+	}
+	return fsys.Stat(path)
+}
+
+// Where the symbolic link at path on fsys leads: the resolved path of its target and the target's FileInfo. The
+// link is broken when err is not nil, in which case target is what the link says, if that can be read
+func ResolveLink(fsys afero.Fs, path string) (target string, info fs.FileInfo, err error) {
+	if target, err = evalSymlinks(fsys, path); err != nil {
+		target, _ = readlink(fsys, path)
+		return target, nil, err
+	}
+	info, err = fsys.Stat(target)
+	return target, info, err
+}
+
+// os.Readlink on fsys
+func readlink(fsys afero.Fs, path string) (string, error) {
+	if reader, ok := fsys.(afero.LinkReader); ok {
+		return reader.ReadlinkIfPossible(path)
+	}
+	return "", &os.PathError{Op: "readlink", Path: path, Err: afero.ErrNoReadlink}
+}
+
+// filepath.EvalSymlinks on fsys. Other filesystems only have the links in the last element of path resolved
+func evalSymlinks(fsys afero.Fs, path string) (string, error) {
+	if _, ok := fsys.(*afero.OsFs); ok {
+		return filepath.EvalSymlinks(path)
+	}
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		info, err := Lstat(fsys, path)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := readlink(fsys, path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", &os.PathError{Op: "evalsymlinks", Path: path, Err: errors.New("too many links")}
+}
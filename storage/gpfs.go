@@ -0,0 +1,47 @@
+//go:build !nogpfs
+
+package storage
+
+import "unsafe"
+
+// #cgo LDFLAGS: -L ../attr_check/lib -lgpfs -lstdc++ -lattr_check
+// #cgo CFLAGS: -I ../attr_check
+// #include <stdlib.h>
+// #include "attr_check.h"
+import "C"
+
+// Size of the buffers handed to attr_dump() and storage_pool()
+const attrBufSize = 1024
+
+// Backend reading the GPFS attributes with libgpfs. The C functions can't report errors: files they can't read are
+// resident and have no attributes or pool
+type GPFS struct{}
+
+// Wrapper around attr_check(), which calls gpfs_fgetattrs()
+func (GPFS) State(path string) (State, error) {
+	cs := C.CString(path)
+	defer C.free(unsafe.Pointer(cs))
+	return State(C.attr_check(cs)), nil
+}
+
+// Wrapper around attr_dump()
+func (GPFS) Attributes(path string) (string, error) {
+	cs := C.CString(path)
+	defer C.free(unsafe.Pointer(cs))
+	buf := (*C.char)(C.malloc(attrBufSize))
+	defer C.free(unsafe.Pointer(buf))
+	n := C.attr_dump(cs, buf, attrBufSize)
+	return C.GoStringN(buf, n), nil
+}
+
+// Wrapper around storage_pool(). The pool is "" if unknown
+func (GPFS) Pool(path string) (string, error) {
+	cs := C.CString(path)
+	defer C.free(unsafe.Pointer(cs))
+	buf := (*C.char)(C.malloc(attrBufSize))
+	defer C.free(unsafe.Pointer(buf))
+	if C.storage_pool(cs, buf, attrBufSize) != 0 {
+		return "", nil
+	}
+	return C.GoString(buf), nil
+}
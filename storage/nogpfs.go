@@ -0,0 +1,23 @@
+//go:build nogpfs
+
+package storage
+
+import "errors"
+
+// Returned by GPFS when gls is built with -tags nogpfs
+var ErrNoGPFS = errors.New("storage: built without GPFS support")
+
+// Stands in for the libgpfs backend in builds without it, e.g. to run the tests on machines without GPFS
+type GPFS struct{}
+
+func (GPFS) State(path string) (State, error) {
+	return Unknown, ErrNoGPFS
+}
+
+func (GPFS) Attributes(path string) (string, error) {
+	return "", ErrNoGPFS
+}
+
+func (GPFS) Pool(path string) (string, error) {
+	return "", ErrNoGPFS
+}
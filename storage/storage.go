@@ -0,0 +1,278 @@
+// Package storage answers "what is the HSM state of these files" for Go programs, without any of gls's output
+// formatting. The exported types follow semantic versioning: fields and functions are only ever added, never
+// renamed or removed, within a major version.
+//
+// NewClient and the package level functions take their defaults from github.com/olcf/gls/config; set the fields of
+// a Client to use other roots, workers or limits. gls's own listing (package ls) does not go through Client, since it
+// reads through an afero.Fs for tests and adds the state cache and --stats timings. It shares everything that decides
+// what a file's state is though: the Backend, OnGPFS, ResolveLink, ParseTapeCopies and the limiter.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/olcf/gls/config"
+	"github.com/olcf/gls/throttle"
+
+	"github.com/spf13/afero"
+)
+
+// Storage state of a file. The values match the return codes of attr_check()
+type State int
+
+const (
+	Unknown     State = -1
+	Resident    State = 0
+	Premigrated State = 1
+	Migrated    State = 2
+)
+
+func (s State) String() string {
+	switch s {
+	case Resident:
+		return "resident"
+	case Premigrated:
+		return "premigrated"
+	case Migrated:
+		return "migrated"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for _, state := range []State{Unknown, Resident, Premigrated, Migrated} {
+		if string(text) == state.String() {
+			*s = state
+			return nil
+		}
+	}
+	return errors.New("storage: unknown state " + string(text))
+}
+
+// A single tape copy of a migrated/premigrated file as recorded by Spectrum Archive
+type TapeCopy struct {
+	Volser  string `json:"volser"`
+	Pool    string `json:"pool"`
+	Library string `json:"library"`
+}
+
+// A file and its storage state
+type Entry struct {
+	Path    string      `json:"path"`
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	IsDir   bool        `json:"is_dir"`
+	// Whether the file is below one of the client's Roots. Files elsewhere are always Unknown
+	OnGPFS bool  `json:"on_gpfs"`
+	State  State `json:"state"`
+	// Only populated when Client.Pools is set. TapeCopies is empty for resident files
+	Pool       string     `json:"pool,omitempty"`
+	TapeCopies []TapeCopy `json:"tape_copies,omitempty"`
+	// Only populated for symbolic links. State is the state of the target
+	Target string `json:"target,omitempty"`
+	Broken bool   `json:"broken,omitempty"`
+}
+
+// Returned by StatMany for every path. Entry.Path is set even when Err is not nil
+type Result struct {
+	Entry Entry
+	Err   error
+}
+
+// Where the storage state comes from. Implementations must be safe for concurrent use
+type Backend interface {
+	// Storage state of the file at path, following symbolic links
+	State(path string) (State, error)
+	// Printable HSM attributes of the file with '|' between attributes
+	Attributes(path string) (string, error)
+	// GPFS storage pool holding the data on disk
+	Pool(path string) (string, error)
+}
+
+// Implemented by backends that look up many files faster in one go, like the glsd client. Callers about to look
+// up paths one at a time call Prefetch with all of them first. The answers are kept until the next Prefetch, so
+// calling it with no paths makes later lookups current again
+type Prefetcher interface {
+	Prefetch(paths []string, pools bool)
+}
+
+// Backend used by the package level functions and by NewClient. GPFS unless replaced, e.g. with a fake in tests
+var DefaultBackend Backend = GPFS{}
+
+// Looks up files with a Backend. The zero value is not usable, use NewClient
+type Client struct {
+	Backend Backend
+	// Only files below these paths are asked for their state
+	Roots []string
+	// Also look up the storage pool and tape copies, which costs an extra call per file
+	Pools bool
+	// Maximum number of files StatMany looks up at once
+	Workers int
+	// Bounds the lookups of every StatMany of the client together when set, see package throttle
+	Limiter *throttle.Limiter
+}
+
+// A Client using DefaultBackend, config.GpfsRoots, config.MaxGoRoutines workers and a throttle.Default limiter
+func NewClient() *Client {
+	return &Client{
+		Backend: DefaultBackend,
+		Roots:   config.GpfsRoots,
+		Workers: config.MaxGoRoutines,
+		Limiter: throttle.Default(),
+	}
+}
+
+// Stat with a default Client
+func Stat(ctx context.Context, path string) (Entry, error) {
+	return NewClient().Stat(ctx, path)
+}
+
+// StatMany with a default Client
+func StatMany(ctx context.Context, paths []string) <-chan Result {
+	return NewClient().StatMany(ctx, paths)
+}
+
+// Walk with a default Client
+func Walk(ctx context.Context, root string, fn func(e Entry, err error) error) error {
+	return NewClient().Walk(ctx, root, fn)
+}
+
+// Look up a single file. Symbolic links are not followed, but the state of their target is reported
+func (c *Client) Stat(ctx context.Context, path string) (Entry, error) {
+	e := Entry{Path: path, Name: filepath.Base(path), State: Unknown}
+	if err := ctx.Err(); err != nil {
+		return e, err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return e, err
+	}
+	e.Size, e.Mode, e.ModTime, e.IsDir = info.Size(), info.Mode(), info.ModTime(), info.IsDir()
+	regular := info.Mode().IsRegular()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var target fs.FileInfo
+		if e.Target, target, err = ResolveLink(afero.NewOsFs(), path); err != nil {
+			e.Broken = true
+		} else {
+			regular = target.Mode().IsRegular()
+		}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return e, err
+	}
+	e.OnGPFS = OnGPFS(abs, c.Roots)
+	if !e.OnGPFS || !regular {
+		return e, nil
+	}
+	if e.State, err = c.Backend.State(path); err != nil {
+		e.State = Unknown
+		return e, err
+	}
+	if c.Pools {
+		if e.Pool, err = c.Backend.Pool(path); err != nil {
+			return e, err
+		}
+		if e.State == Premigrated || e.State == Migrated {
+			attrs, err := c.Backend.Attributes(path)
+			if err != nil {
+				return e, err
+			}
+			e.TapeCopies = ParseTapeCopies(attrs)
+		}
+	}
+	return e, nil
+}
+
+// Look up paths with up to Workers lookups at once. Results arrive in the order they finish and the channel is
+// closed once every path is done or ctx is cancelled
+func (c *Client) StatMany(ctx context.Context, paths []string) <-chan Result {
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	if p, ok := c.Backend.(Prefetcher); ok {
+		p.Prefetch(paths, c.Pools)
+	}
+	input := make(chan string)
+	output := make(chan Result)
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range input {
+				var e Entry
+				var err error
+				c.Limiter.Do(func() { e, err = c.Stat(ctx, path) })
+				select {
+				case output <- Result{Entry: e, Err: err}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(input)
+		for _, path := range paths {
+			select {
+			case input <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(output)
+	}()
+	return output
+}
+
+// Call fn for root and every file below it in lexical order, like filepath.WalkDir. err is the error from reading
+// a directory or looking up the entry, and fn decides whether it stops the walk by returning it. Returning fs.SkipDir
+// skips the directory. Stops with ctx.Err() once ctx is cancelled
+func (c *Client) Walk(ctx context.Context, root string, fn func(e Entry, err error) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return fn(Entry{Path: path, Name: filepath.Base(path), State: Unknown}, err)
+		}
+		return fn(c.Stat(ctx, path))
+	})
+}
+
+// Spectrum Archive records each copy in the IBMTPS attribute as VOLSER@POOL@LIBRARY
+var tapeCopyRex = regexp.MustCompile(`(?:^|[^A-Za-z0-9])([A-Z0-9]{6,8})@([A-Za-z0-9_.\-]+)@([A-Za-z0-9_.\-]+)`)
+
+// Pull the tape copies out of the raw attributes returned by Backend.Attributes. Duplicate volsers are only reported once
+func ParseTapeCopies(raw string) []TapeCopy {
+	var copies []TapeCopy
+	seen := make(map[string]bool)
+	for _, m := range tapeCopyRex.FindAllStringSubmatch(raw, -1) {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		copies = append(copies, TapeCopy{Volser: m[1], Pool: m[2], Library: m[3]})
+	}
+	return copies
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// States and attributes by file name
+type testBackend map[string]State
+
+func (b testBackend) State(path string) (State, error) {
+	state, ok := b[filepath.Base(path)]
+	if !ok {
+		return Unknown, errors.New("no attributes")
+	}
+	return state, nil
+}
+
+func (b testBackend) Attributes(path string) (string, error) {
+	return "IBMObj|8|IBMTPS|1 JD0147JD@primary@lib1", nil
+}
+
+func (b testBackend) Pool(path string) (string, error) {
+	return "system", nil
+}
+
+func testTree(t *testing.T) (string, *Client) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"a": "hi", "b": "hello", "sub/c": "", "sub/bad": ""} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("b", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "broken")); err != nil {
+		t.Fatal(err)
+	}
+	backend := testBackend{"a": Resident, "b": Migrated, "link": Migrated, "c": Premigrated}
+	return dir, &Client{Backend: backend, Roots: []string{dir}, Workers: 2}
+}
+
+func TestStat(t *testing.T) {
+	dir, c := testTree(t)
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		state State
+	}{
+		{"a", Resident},
+		{"b", Migrated},
+		{"link", Migrated},
+		{"broken", Unknown},
+		{"sub", Unknown},
+	}
+	for _, test := range tests {
+		e, err := c.Stat(ctx, filepath.Join(dir, test.name))
+		if err != nil || e.State != test.state || !e.OnGPFS {
+			t.Fatalf("storage.Stat(%s) = %v, %v; want state %s on GPFS", test.name, e, err, test.state)
+		}
+	}
+
+	e, _ := c.Stat(ctx, filepath.Join(dir, "broken"))
+	if !e.Broken || e.Target != "missing" {
+		t.Fatalf("storage.Stat(broken) = %v; want broken link to missing", e)
+	}
+	if _, err := c.Stat(ctx, filepath.Join(dir, "sub", "bad")); err == nil {
+		t.Fatalf("storage.Stat(sub/bad) = nil; want backend error")
+	}
+
+	c.Pools = true
+	e, _ = c.Stat(ctx, filepath.Join(dir, "b"))
+	if want := []TapeCopy{{Volser: "JD0147JD", Pool: "primary", Library: "lib1"}}; e.Pool != "system" || !reflect.DeepEqual(e.TapeCopies, want) {
+		t.Fatalf("storage.Stat(b) with pools = %v; want pool system and tape copies %v", e, want)
+	}
+
+	c.Roots = []string{"/gpfs"}
+	if e, err := c.Stat(ctx, filepath.Join(dir, "a")); err != nil || e.OnGPFS || e.State != Unknown {
+		t.Fatalf("storage.Stat(a) outside roots = %v, %v; want unknown state", e, err)
+	}
+}
+
+func TestStatMany(t *testing.T) {
+	dir, c := testTree(t)
+	paths := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "missing")}
+	var have []string
+	for r := range c.StatMany(context.Background(), paths) {
+		have = append(have, r.Entry.Name+" "+r.Entry.State.String()+" "+fmt.Sprint(r.Err != nil))
+	}
+	sort.Strings(have)
+	want := []string{"a resident false", "b migrated false", "missing unknown true"}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("storage.StatMany(%v) = %v; want %v", paths, have, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for r := range c.StatMany(ctx, paths) {
+		if r.Err == nil {
+			t.Fatalf("storage.StatMany(cancelled) = %v; want context error", r)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir, c := testTree(t)
+	var have []string
+	err := c.Walk(context.Background(), dir, func(e Entry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, e.Path)
+		have = append(have, rel+" "+e.State.String())
+		if e.Name == "sub" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	want := []string{". unknown", "a resident", "b migrated", "broken unknown", "link migrated", "sub unknown"}
+	if err != nil || !reflect.DeepEqual(have, want) {
+		t.Fatalf("storage.Walk(%s) = %v, %v; want %v", dir, have, err, want)
+	}
+
+	stop := errors.New("stop")
+	if err := c.Walk(context.Background(), dir, func(e Entry, err error) error { return stop }); err != stop {
+		t.Fatalf("storage.Walk(%s) = %v; want %v", dir, err, stop)
+	}
+}
+
+func TestParseTapeCopies(t *testing.T) {
+	raw := "IBMObj|8|IBMPMig|IBMTPS|2 JD0147JD@primary@lib1:JD0212JD@copy@lib2|IBMUID|abc"
+	have := ParseTapeCopies(raw)
+	want := []TapeCopy{
+		{Volser: "JD0147JD", Pool: "primary", Library: "lib1"},
+		{Volser: "JD0212JD", Pool: "copy", Library: "lib2"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("storage.ParseTapeCopies(%s) = %v; want %v", raw, have, want)
+	}
+	if have = ParseTapeCopies("IBMObj|8"); have != nil {
+		t.Fatalf("storage.ParseTapeCopies(%s) = %v; want nil", "IBMObj|8", have)
+	}
+}
+
+func TestState(t *testing.T) {
+	for _, state := range []State{Unknown, Resident, Premigrated, Migrated} {
+		text, _ := state.MarshalText()
+		var have State
+		if err := have.UnmarshalText(text); err != nil || have != state {
+			t.Fatalf("storage.State.UnmarshalText(%s) = %v, %v; want %v", text, have, err, state)
+		}
+	}
+}
+
+func TestOnGPFS(t *testing.T) {
+	roots := []string{"/gpfs/themis", "/gpfs/alpine/"}
+	tests := map[string]bool{
+		"/gpfs/themis":          true,
+		"/gpfs/themis/proj/a":   true,
+		"/gpfs/alpine/proj":     true,
+		"/gpfs/themis2/proj":    false,
+		"/home/gpfs/themis/a":   false,
+		"/tmp/gpfs/themis/proj": false,
+	}
+	for path, want := range tests {
+		if have := OnGPFS(path, roots); have != want {
+			t.Fatalf("storage.OnGPFS(%s, %v) = %t; want %t", path, roots, have, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package throttle keeps gls from flooding the GPFS metadata servers. A Limiter bounds the file lookups in flight
+// across a whole run and adapts the bound to how fast the lookups come back: it grows by one for every bound's
+// worth of lookups faster than the target latency and halves when they get slower (AIMD, like TCP congestion
+// control). An optional rate limit caps the lookups per second on top of that.
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/olcf/gls/config"
+)
+
+// Bounds the lookups in flight and their rate. Safe for concurrent use; a nil Limiter doesn't limit anything
+type Limiter struct {
+	// Ceiling of the bound
+	Max int
+	// Lookups slower than this halve the bound
+	Target time.Duration
+	// Most lookups started per second. 0 means no limit
+	Rate float64
+	// Keep the bound at Max instead of adapting it
+	Fixed bool
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	limit        float64
+	inFlight     int
+	peak         int
+	next         time.Time
+	lastDecrease time.Time
+}
+
+// A Limiter allowing start lookups at once at first and never more than max
+func New(start, max int, target time.Duration, rate float64) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+	if start < 1 || start > max {
+		start = max
+	}
+	l := &Limiter{Max: max, Target: target, Rate: rate, limit: float64(start)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// A Limiter configured by config.StatStartGoRoutines, config.MaxGoRoutines, config.StatTargetLatency and
+// config.StatRateLimit. With config.AlwaysUseMaxGoRoutines it stays at config.MaxGoRoutines
+func Default() *Limiter {
+	start := config.StatStartGoRoutines
+	if config.AlwaysUseMaxGoRoutines {
+		start = config.MaxGoRoutines
+	}
+	l := New(start, config.MaxGoRoutines, config.StatTargetLatency, config.StatRateLimit)
+	l.Fixed = config.AlwaysUseMaxGoRoutines
+	return l
+}
+
+// Wait until another lookup may start
+func (l *Limiter) Acquire() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	for l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	if l.inFlight > l.peak {
+		l.peak = l.inFlight
+	}
+	// Each lookup reserves the next slot in time, so the rate holds however many are waiting
+	var wait time.Duration
+	if l.Rate > 0 {
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait = l.next.Sub(now)
+		l.next = l.next.Add(time.Duration(float64(time.Second) / l.Rate))
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// Report that a lookup started with Acquire took latency
+func (l *Limiter) Release(latency time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if !l.Fixed {
+		if latency > l.Target {
+			// A burst of slow lookups all finishing at once only counts as one sign of overload
+			if now := time.Now(); now.Sub(l.lastDecrease) > l.Target {
+				l.limit /= 2
+				l.lastDecrease = now
+			}
+		} else {
+			l.limit += 1 / l.limit
+		}
+		if l.limit < 1 {
+			l.limit = 1
+		}
+		if l.limit > float64(l.Max) {
+			l.limit = float64(l.Max)
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// Run f as one lookup
+func (l *Limiter) Do(f func()) {
+	l.Acquire()
+	start := time.Now()
+	defer func() { l.Release(time.Since(start)) }()
+	f()
+}
+
+// The current bound on lookups in flight. 0 for a nil Limiter
+func (l *Limiter) Limit() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// The most lookups that were in flight at once
+func (l *Limiter) Peak() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak
+}
@@ -0,0 +1,90 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAIMD(t *testing.T) {
+	l := New(2, 4, time.Second, 0)
+	// Fast lookups raise the bound by about one per bound's worth of lookups, up to Max
+	for i := 0; i < 100; i++ {
+		l.Do(func() {})
+	}
+	if have := l.Limit(); have != 4 {
+		t.Fatalf("throttle.Limit() after fast lookups = %v; want %v", have, 4)
+	}
+	// A slow lookup halves it, but more slow ones right after don't
+	l.Acquire()
+	l.Release(2 * time.Second)
+	l.Acquire()
+	l.Release(2 * time.Second)
+	if have := l.Limit(); have != 2 {
+		t.Fatalf("throttle.Limit() after slow lookups = %v; want %v", have, 2)
+	}
+	l.Fixed = true
+	for i := 0; i < 100; i++ {
+		l.Do(func() {})
+	}
+	if have := l.Limit(); have != 2 {
+		t.Fatalf("throttle.Limit() of a fixed limiter = %v; want %v", have, 2)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	l := New(3, 3, time.Hour, 0)
+	var mu sync.Mutex
+	var inFlight, peak int
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Do(func() {
+				mu.Lock()
+				inFlight++
+				if inFlight > peak {
+					peak = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	if peak > 3 || l.Peak() > 3 {
+		t.Fatalf("lookups in flight = %v (Peak() %v); want at most %v", peak, l.Peak(), 3)
+	}
+}
+
+func TestRate(t *testing.T) {
+	l := New(10, 10, time.Hour, 100)
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		l.Do(func() {})
+	}
+	// The first lookup starts straight away, the other ten 10ms apart
+	if have := time.Since(start); have < 90*time.Millisecond {
+		t.Fatalf("11 lookups at 100/s took %v; want at least %v", have, 100*time.Millisecond)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var l *Limiter
+	ran := false
+	l.Do(func() { ran = true })
+	if !ran || l.Limit() != 0 || l.Peak() != 0 {
+		t.Fatalf("nil throttle.Limiter: ran %v, Limit() %v, Peak() %v; want true, 0, 0", ran, l.Limit(), l.Peak())
+	}
+}
+
+func TestNew(t *testing.T) {
+	// Ceilings below one, like half the CPUs of a single CPU machine, still allow one lookup
+	if have := New(4, 0, time.Second, 0).Limit(); have != 1 {
+		t.Fatalf("throttle.New(4, 0).Limit() = %v; want %v", have, 1)
+	}
+}